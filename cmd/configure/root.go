@@ -21,19 +21,30 @@ package cmd
 // THE SOFTWARE.
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/bhojpur/deploy/pkg/console"
 	"github.com/bhojpur/deploy/pkg/executor"
+	"github.com/bhojpur/deploy/pkg/journal"
 	"github.com/bhojpur/deploy/pkg/logger"
+	"github.com/bhojpur/deploy/pkg/plugins"
 	"github.com/bhojpur/deploy/pkg/schema"
+	"github.com/bhojpur/deploy/pkg/utils"
 	"github.com/bhojpur/deploy/pkg/version"
+	"github.com/hashicorp/go-multierror"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/twpayne/go-vfs"
+	"gopkg.in/yaml.v2"
 )
 
 func initLogger() logger.Interface {
@@ -62,20 +73,62 @@ For example:
 	$> depcfg -s initramfs https://<deploy.yaml> /path/to/disk <definition.yaml> ...
 	$> depcfg -s initramfs <deploy.yaml> <deploy2.yaml> ...
 	$> depcfg def.yaml | depcfg -
+	$> depcfg --dry-run -s boot <deploy.yaml>
+	$> depcfg --before-after -s initramfs,boot /oem
+	$> depcfg --parallel 4 -s network /oem
+	$> depcfg --disable-plugins commands,git -s initramfs /oem
+	$> depcfg --var hostname=node1 --vars-file /etc/depcfg/vars.yaml -s boot /oem
+	$> depcfg --timeout 10m -s boot /oem
+	$> depcfg --cmdline -s initramfs /oem
+	$> depcfg --events-fd 3 -s boot /oem 3>events.json
+	$> depcfg --report junit:/tmp/out.xml --report json:- -s boot /oem
+	$> depcfg --report prom:/var/lib/node_exporter/textfile/depcfg.prom -s boot /oem
 `,
+	// Paths and URLs, which must not be taken for unknown subcommands
+	Args: cobra.ArbitraryArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		stage, _ := cmd.Flags().GetString("stage")
 		dot, _ := cmd.Flags().GetBool("dotnotation")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		output, _ := cmd.Flags().GetString("output")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		reports, _ := cmd.Flags().GetStringArray("report")
+		cmdline, _ := cmd.Flags().GetBool("cmdline")
+		cmdlinePrefix, _ := cmd.Flags().GetString("cmdline-prefix")
+
+		stages := executor.SplitStages(stage)
+		if len(stages) == 0 {
+			return fmt.Errorf("no stage given")
+		}
+		if output != "text" && output != "json" {
+			return fmt.Errorf("invalid output format '%s', must be one of: text, json", output)
+		}
+		for _, r := range reports {
+			if _, _, err := parseReport(r); err != nil {
+				return err
+			}
+		}
 
 		ll := initLogger()
-		runner := executor.NewExecutor(executor.WithLogger(ll))
+		redactor := utils.NewRedactor()
+		fs, consoleOpts, err := targetFS(cmd)
+		if err != nil {
+			return err
+		}
+		opts, closeOpts, err := executorOptions(cmd, fs, ll, redactor)
+		if err != nil {
+			return err
+		}
+		defer closeOpts()
+		runner := executor.NewExecutor(opts...)
 		fromStdin := len(args) == 1 && args[0] == "-"
 
 		ll.Infof("Bhojpur Deploy configure version %s", cmd.Version)
-		if len(args) == 0 {
-			ll.Fatal("depcfg needs at least one path or URL as argument")
+		if len(args) == 0 && !cmdline {
+			ll.Fatal("depcfg needs at least one path or URL as argument, or --cmdline")
 		}
-		stdConsole := console.NewStandardConsole(console.WithLogger(ll))
+		// The commands run by the steps can contain their secrets
+		stdConsole := console.NewStandardConsole(append(consoleOpts, console.WithLogger(logger.WithRedaction(ll, redactor.Redact)))...)
 
 		if dot {
 			runner.Modifier(schema.DotNotationModifier)
@@ -89,11 +142,211 @@ For example:
 
 			args = []string{string(std)}
 		}
+		if cmdline {
+			args = append(args, schema.CmdlineScheme+cmdlinePrefix)
+		}
 
-		return runner.Run(stage, vfs.OSFS, stdConsole, args...)
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		err = runner.RunStages(ctx, stages, fs, stdConsole, args...)
+		if dryRun {
+			if output == "json" {
+				runner.Plan().WriteJSON(os.Stdout)
+			} else {
+				runner.Plan().WriteText(os.Stdout)
+			}
+		}
+		for _, r := range reports {
+			if werr := writeReport(runner.Report(), r); werr != nil {
+				err = multierror.Append(err, werr)
+			}
+		}
+		if err != nil {
+			// The errors of the commands can contain the secrets of the steps
+			return errors.New(redactor.Redact(err.Error()))
+		}
+		return nil
 	},
 }
 
+// executorOptions returns the options of the executor set by the flags, for the steps
+// applying to fs, and a function closing the files they opened
+func executorOptions(cmd *cobra.Command, fs vfs.FS, ll logger.Interface, redactor *utils.Redactor) ([]executor.Options, func(), error) {
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	parallel, _ := cmd.Flags().GetInt("parallel")
+	failFast, _ := cmd.Flags().GetBool("fail-fast")
+	journalPath, _ := cmd.Flags().GetString("journal")
+	eventsFd, _ := cmd.Flags().GetInt("events-fd")
+	eventsFile, _ := cmd.Flags().GetString("events-file")
+	companions, _ := cmd.Flags().GetBool("before-after")
+	enabled, _ := cmd.Flags().GetStringSlice("enable-plugins")
+	disabled, _ := cmd.Flags().GetStringSlice("disable-plugins")
+	lockFile, _ := cmd.Flags().GetString("lock-file")
+	lockTimeout, _ := cmd.Flags().GetDuration("lock-timeout")
+	noLock, _ := cmd.Flags().GetBool("no-lock")
+	varsFile, _ := cmd.Flags().GetString("vars-file")
+	varPairs, _ := cmd.Flags().GetStringArray("var")
+
+	registry := executor.DefaultRegistry()
+	for _, p := range append(enabled, disabled...) {
+		if _, ok := registry.Lookup(p); !ok {
+			return nil, nil, fmt.Errorf("unknown plugin '%s', must be one of: %s", p, strings.Join(registry.Names(), ", "))
+		}
+	}
+
+	vars, err := loadVars(varsFile, varPairs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := []executor.Options{
+		executor.WithLogger(ll),
+		executor.WithRedactor(redactor),
+		executor.WithDryRun(dryRun),
+		executor.WithParallel(parallel),
+		executor.WithFailFast(failFast),
+		executor.WithCompanionStages(companions),
+		executor.WithEnabledPlugins(enabled...),
+		executor.WithDisabledPlugins(disabled...),
+		executor.WithVars(vars),
+	}
+	if _, ok := plugins.OfflineRoot(fs); ok {
+		// The configs come from the host, the state from the offline root
+		opts = append(opts, executor.WithSourceFS(vfs.OSFS))
+	}
+	if !noLock {
+		opts = append(opts, executor.WithLock(lockFile, lockTimeout))
+	}
+	if journalPath != "" {
+		j, err := journal.Load(journalPath, fs)
+		if err != nil {
+			return nil, nil, err
+		}
+		opts = append(opts, executor.WithJournal(j))
+	}
+
+	var files []*os.File
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+	if eventsFd > 0 {
+		f := os.NewFile(uintptr(eventsFd), "events")
+		files = append(files, f)
+		opts = append(opts, executor.WithEventHandler(executor.JSONLinesHandler(f)))
+	}
+	if eventsFile != "" {
+		f, err := os.OpenFile(eventsFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			closeFiles()
+			return nil, nil, err
+		}
+		files = append(files, f)
+		opts = append(opts, executor.WithEventHandler(executor.JSONLinesHandler(f)))
+	}
+	return opts, closeFiles, nil
+}
+
+// targetFS returns the fs the steps apply to, and the options of the console running their
+// commands: the offline root given with --root if any, the running system otherwise
+func targetFS(cmd *cobra.Command) (vfs.FS, []console.StandardConsoleOptions, error) {
+	root, _ := cmd.Flags().GetString("root")
+	if root == "" {
+		return vfs.OSFS, nil, nil
+	}
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f, err := os.Stat(root); err != nil {
+		return nil, nil, fmt.Errorf("invalid root: %w", err)
+	} else if !f.IsDir() {
+		return nil, nil, fmt.Errorf("invalid root %s: not a directory", root)
+	}
+	return plugins.NewRootFS(root), []console.StandardConsoleOptions{console.WithChroot(root)}, nil
+}
+
+// loadVars reads the variables of the vars file, if any, and overrides them with
+// the key=value pairs
+func loadVars(file string, pairs []string) (map[string]string, error) {
+	vars := map[string]string{}
+	if file != "" {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &vars); err != nil {
+			return nil, fmt.Errorf("invalid vars file %s: %w", file, err)
+		}
+	}
+	for _, p := range pairs {
+		parts := strings.SplitN(p, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid var '%s', must be <key>=<value>", p)
+		}
+		vars[parts[0]] = parts[1]
+	}
+	return vars, nil
+}
+
+// parseReport splits a --report value into its format and path
+func parseReport(r string) (format, path string, err error) {
+	parts := strings.SplitN(r, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid report '%s', must be <format>:<path>", r)
+	}
+	if parts[0] != "json" && parts[0] != "junit" && parts[0] != "prom" {
+		return "", "", fmt.Errorf("invalid report format '%s', must be one of: json, junit, prom", parts[0])
+	}
+	return parts[0], parts[1], nil
+}
+
+// writeReport writes the run report as a --report value says. Files are replaced
+// atomically, so readers like node_exporter never see a partial report.
+func writeReport(report *executor.RunReport, r string) error {
+	format, path, err := parseReport(r)
+	if err != nil {
+		return err
+	}
+
+	write := report.WriteJSON
+	switch format {
+	case "junit":
+		write = report.WriteJUnit
+	case "prom":
+		write = report.WritePrometheus
+	}
+	if path == "-" {
+		return write(os.Stdout)
+	}
+
+	// The temporary file doesn't end in .prom, so the textfile collector skips it
+	w, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(w.Name())
+	if err := write(w); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Chmod(0644); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return os.Rename(w.Name(), path)
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
@@ -104,6 +357,26 @@ func Execute() {
 }
 
 func init() {
-	rootCmd.PersistentFlags().StringP("stage", "s", "default", "Stage to apply")
+	rootCmd.PersistentFlags().StringP("stage", "s", "default", "Stage to apply, or comma separated stages to apply in order")
+	rootCmd.PersistentFlags().Bool("before-after", false, "Also run the <stage>.before and <stage>.after stages around each stage")
 	rootCmd.PersistentFlags().BoolP("dotnotation", "d", false, "Parse input in dotnotation ( e.g. `stages.foo.name=..` ) ")
+	rootCmd.PersistentFlags().Bool("dry-run", false, "Report the changes the stage would apply without applying them")
+	rootCmd.PersistentFlags().StringP("output", "o", "text", "Output format of the dry-run report and of the state command ( text, json )")
+	rootCmd.PersistentFlags().Int("parallel", 1, "Number of independent stage steps to run concurrently")
+	rootCmd.PersistentFlags().Duration("timeout", 0, "Maximum time to run the stage for ( e.g. 10m ), 0 means no limit")
+	rootCmd.PersistentFlags().Bool("fail-fast", false, "Stop at the first failing step, unless it sets a different on_failure policy")
+	rootCmd.PersistentFlags().String("journal", journal.DefaultPath, "State file recording the applied steps, empty to disable it")
+	rootCmd.PersistentFlags().Int("events-fd", 0, "File descriptor to write the executor events to, as JSON lines")
+	rootCmd.PersistentFlags().StringArray("report", []string{}, "Write the run report as <format>:<path>, with format json, junit or prom and - for stdout")
+	rootCmd.PersistentFlags().String("events-file", "", "File to append the executor events to, as JSON lines")
+	rootCmd.PersistentFlags().StringSlice("enable-plugins", []string{}, "Comma separated plugins to run, all of them if empty")
+	rootCmd.PersistentFlags().StringSlice("disable-plugins", []string{}, "Comma separated plugins not to run")
+	rootCmd.PersistentFlags().String("lock-file", executor.DefaultLockPath, "Lock file keeping depcfg runs from overlapping")
+	rootCmd.PersistentFlags().Duration("lock-timeout", 5*time.Minute, "Maximum time to wait for another run to release the lock")
+	rootCmd.PersistentFlags().Bool("no-lock", false, "Run without taking the lock")
+	rootCmd.PersistentFlags().StringArray("var", []string{}, "Set a variable for the templates as <key>=<value>, overriding the ones of the configs")
+	rootCmd.PersistentFlags().String("vars-file", "", "YAML file with the variables for the templates, overridden by --var")
+	rootCmd.PersistentFlags().Bool("cmdline", false, "Also load a config from the kernel parameters, as the cmdline:// source")
+	rootCmd.PersistentFlags().String("root", "", "Apply the configs to the system mounted at this path, skipping the host-only actions and running the commands chrooted")
+	rootCmd.PersistentFlags().String("cmdline-prefix", schema.DefaultCmdlinePrefix, "Prefix of the kernel parameters loaded with --cmdline")
 }
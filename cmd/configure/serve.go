@@ -0,0 +1,120 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/bhojpur/deploy/pkg/console"
+	"github.com/bhojpur/deploy/pkg/executor"
+	"github.com/bhojpur/deploy/pkg/logger"
+	"github.com/bhojpur/deploy/pkg/schema"
+	"github.com/bhojpur/deploy/pkg/server"
+	"github.com/bhojpur/deploy/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// DefaultSocket is the unix socket the API is served on by default
+const DefaultSocket = "/run/depcfg.sock"
+
+var serveCmd = &cobra.Command{
+	Use:   "serve [source...]",
+	Short: "Serve an API to submit configs and run stages",
+	Long: `Serves an HTTP API on a unix socket, to submit configs and run stages against
+the sources given and the configs submitted. Runs are queued, and run one at a time.
+
+	GET    /configs             names of the submitted configs
+	PUT    /configs/<name>      submit a config, as YAML, applied by the next runs
+	DELETE /configs/<name>      remove a submitted config
+	GET    /runs                the runs, the latest last
+	POST   /runs                queue a run, with a body like {"stages": ["boot"]}
+	GET    /runs/<id>           status of a run
+	GET    /runs/<id>/report    report of a run, once started
+
+For example:
+	$> depcfg serve --socket /run/depcfg.sock /oem
+	$> curl --unix-socket /run/depcfg.sock -d '{"stages": ["network"]}' http://depcfg/runs
+`,
+	Args: cobra.ArbitraryArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dot, _ := cmd.Flags().GetBool("dotnotation")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		socket, _ := cmd.Flags().GetString("socket")
+		queueSize, _ := cmd.Flags().GetInt("queue-size")
+
+		ll := initLogger()
+		redactor := utils.NewRedactor()
+		fs, consoleOpts, err := targetFS(cmd)
+		if err != nil {
+			return err
+		}
+		opts, closeOpts, err := executorOptions(cmd, fs, ll, redactor)
+		if err != nil {
+			return err
+		}
+		defer closeOpts()
+
+		newExecutor := func() executor.Executor {
+			e := executor.NewExecutor(opts...)
+			if dot {
+				e.Modifier(schema.DotNotationModifier)
+			}
+			return e
+		}
+		srv := server.New(newExecutor,
+			server.WithLogger(ll),
+			server.WithSources(args...),
+			server.WithFS(fs),
+			server.WithConsole(console.NewStandardConsole(append(consoleOpts, console.WithLogger(logger.WithRedaction(ll, redactor.Redact)))...)),
+			server.WithQueueSize(queueSize),
+			server.WithRunTimeout(timeout),
+			server.WithRedaction(redactor.Redact),
+		)
+
+		// A socket left by a previous server would make listening fail
+		if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(socket)
+		if err := os.Chmod(socket, 0600); err != nil {
+			return err
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		ll.Infof("Bhojpur Deploy configure version %s, serving on %s", cmd.Version, socket)
+		return srv.Serve(ctx, l)
+	},
+}
+
+func init() {
+	serveCmd.Flags().String("socket", DefaultSocket, "Unix socket to serve the API on")
+	serveCmd.Flags().Int("queue-size", 16, "Number of runs which can wait for the current one")
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,125 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/bhojpur/deploy/pkg/journal"
+	tablewriter "github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var stateCmd = &cobra.Command{
+	Use:   "state",
+	Short: "List the steps recorded in the journal",
+	Long: `Lists the stage steps recorded in the journal, with the time and the result
+of their last run. Steps marked as 'once' or 'run: on_change' are skipped
+according to these entries.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, _ := cmd.Flags().GetString("output")
+		j, err := loadJournal(cmd)
+		if err != nil {
+			return err
+		}
+
+		keys := j.Keys()
+		if output == "json" {
+			entries := map[string]journal.Entry{}
+			for _, k := range keys {
+				entries[k], _ = j.Get(k)
+			}
+			data, _ := json.MarshalIndent(entries, "", "  ")
+			fmt.Println(string(data))
+			return nil
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Key", "Result", "Time", "Hash", "Error"})
+		for _, k := range keys {
+			e, _ := j.Get(k)
+			hash := e.Hash
+			if len(hash) > 12 {
+				hash = hash[:12]
+			}
+			table.Append([]string{k, e.Result, e.Time.Local().Format(time.RFC3339), hash, e.Error})
+		}
+		table.Render()
+		return nil
+	},
+}
+
+var stateResetCmd = &cobra.Command{
+	Use:   "reset [key...]",
+	Short: "Remove entries from the journal",
+	Long: `Removes the given entries from the journal, or all of them with --all,
+so the corresponding steps run again.
+
+For example:
+	$> depcfg state reset boot/network
+	$> depcfg state reset --all
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+		if all == (len(args) > 0) {
+			return fmt.Errorf("either give the keys to reset or --all")
+		}
+
+		j, err := loadJournal(cmd)
+		if err != nil {
+			return err
+		}
+
+		if all {
+			j.Reset()
+		}
+		for _, k := range args {
+			if !j.Delete(k) {
+				return fmt.Errorf("no journal entry for '%s'", k)
+			}
+		}
+		return j.Save()
+	},
+}
+
+// loadJournal loads the journal of the system the steps apply to, the offline root
+// given with --root if any
+func loadJournal(cmd *cobra.Command) (*journal.Journal, error) {
+	path, _ := cmd.Flags().GetString("journal")
+	if path == "" {
+		return nil, fmt.Errorf("no journal given")
+	}
+	fs, _, err := targetFS(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return journal.Load(path, fs)
+}
+
+func init() {
+	stateResetCmd.Flags().Bool("all", false, "Remove all the entries")
+	stateCmd.AddCommand(stateResetCmd)
+	rootCmd.AddCommand(stateCmd)
+}
@@ -0,0 +1,140 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/bhojpur/deploy/pkg/schema"
+	"github.com/spf13/cobra"
+)
+
+var schemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of the configs",
+	Long: `Prints the JSON Schema the YAML configs follow, generated from the config types,
+e.g. for editors to complete and check the configs.
+
+For example:
+	$> depcfg schema > depcfg.schema.json
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		data, err := json.MarshalIndent(schema.GenerateJSONSchema(), "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	},
+}
+
+var validateCmd = &cobra.Command{
+	Use:   "validate <file...>",
+	Short: "Check configs against the JSON Schema of the configs",
+	Long: `Checks YAML configs against the JSON Schema printed by 'depcfg schema', reporting the
+unknown keys, the values of the wrong type and the values which are not among the allowed
+ones as file:line:column errors. Directories are checked for .yaml and .yml files.
+
+For example:
+	$> depcfg validate /oem/99_custom.yaml
+	$> depcfg validate /system/oem /oem
+`,
+	Args: cobra.MinimumNArgs(1),
+	// Invalid configs are not a misuse of the command
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files, err := validateFiles(args)
+		if err != nil {
+			return err
+		}
+
+		invalid := 0
+		for _, f := range files {
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				return err
+			}
+			errs, err := schema.Validate(data)
+			if err == schema.ErrCloudConfig {
+				fmt.Fprintf(os.Stderr, "%s: skipped, %s\n", f, err.Error())
+				continue
+			}
+			if err != nil {
+				fmt.Printf("%s: %s\n", f, err.Error())
+				invalid++
+				continue
+			}
+			for _, e := range errs {
+				fmt.Printf("%s:%s\n", f, e.Error())
+			}
+			if len(errs) > 0 {
+				invalid++
+			}
+		}
+		if invalid > 0 {
+			return fmt.Errorf("%d of %d config(s) are invalid", invalid, len(files))
+		}
+		return nil
+	},
+}
+
+// validateFiles returns the files to validate, with the config files in the directories given
+func validateFiles(args []string) ([]string, error) {
+	var files []string
+	for _, a := range args {
+		info, err := os.Stat(a)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, a)
+			continue
+		}
+		var found []string
+		err = filepath.Walk(a, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				found = append(found, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		found = configPaths(found)
+		sort.Strings(found)
+		files = append(files, found...)
+	}
+	return files, nil
+}
+
+func init() {
+	rootCmd.AddCommand(schemaCmd)
+	rootCmd.AddCommand(validateCmd)
+}
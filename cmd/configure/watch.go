@@ -0,0 +1,190 @@
+package cmd
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bhojpur/deploy/pkg/console"
+	"github.com/bhojpur/deploy/pkg/executor"
+	"github.com/bhojpur/deploy/pkg/logger"
+	"github.com/bhojpur/deploy/pkg/schema"
+	"github.com/bhojpur/deploy/pkg/utils"
+	"github.com/bhojpur/deploy/pkg/watch"
+	"github.com/spf13/cobra"
+)
+
+var watchCmd = &cobra.Command{
+	Use:   "watch <dir...>",
+	Short: "Apply a stage again whenever the configs change",
+	Long: `Applies the stage, then watches the directories for changes and applies the
+stage again with the configs which changed, or all of them with --full. The stage
+is also applied with all the configs every --interval, if given, and on SIGHUP.
+
+For example:
+	$> depcfg watch -s reconcile /system/oem /oem
+	$> depcfg watch --full --interval 1h -s reconcile /oem
+	$> depcfg watch --report prom:/var/lib/node_exporter/textfile/depcfg.prom -s reconcile /oem
+`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		stage, _ := cmd.Flags().GetString("stage")
+		dot, _ := cmd.Flags().GetBool("dotnotation")
+		dryRun, _ := cmd.Flags().GetBool("dry-run")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		full, _ := cmd.Flags().GetBool("full")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		debounce, _ := cmd.Flags().GetDuration("debounce")
+		reports, _ := cmd.Flags().GetStringArray("report")
+
+		stages := executor.SplitStages(stage)
+		if len(stages) == 0 {
+			return fmt.Errorf("no stage given")
+		}
+		for _, r := range reports {
+			if _, _, err := parseReport(r); err != nil {
+				return err
+			}
+		}
+		for _, dir := range args {
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				return fmt.Errorf("'%s' is not a directory", dir)
+			}
+		}
+
+		ll := initLogger()
+		redactor := utils.NewRedactor()
+		fs, consoleOpts, err := targetFS(cmd)
+		if err != nil {
+			return err
+		}
+		opts, closeOpts, err := executorOptions(cmd, fs, ll, redactor)
+		if err != nil {
+			return err
+		}
+		defer closeOpts()
+		stdConsole := console.NewStandardConsole(append(consoleOpts, console.WithLogger(logger.WithRedaction(ll, redactor.Redact)))...)
+
+		// apply runs the stage with the configs given, or all of them if nil
+		apply := func(ctx context.Context, configs []string) {
+			runOpts := opts
+			if configs != nil {
+				ll.Infof("Applying the changed configs: %s", strings.Join(configs, ", "))
+				runOpts = append(runOpts[:len(runOpts):len(runOpts)], executor.WithSources(configs...))
+			}
+			runner := executor.NewExecutor(runOpts...)
+			if dot {
+				runner.Modifier(schema.DotNotationModifier)
+			}
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+
+			err := runner.RunStages(ctx, stages, fs, stdConsole, args...)
+			if dryRun {
+				runner.Plan().WriteText(os.Stdout)
+			}
+			for _, r := range reports {
+				if werr := writeReport(runner.Report(), r); werr != nil {
+					ll.Errorf("Writing the report %s failed: %s", r, werr.Error())
+				}
+			}
+			if err != nil {
+				ll.Errorf("Applying stage '%s' failed: %s", stage, redactor.Redact(err.Error()))
+			}
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+
+		changes, err := watch.Watch(ctx, ll, debounce, args...)
+		if err != nil {
+			return err
+		}
+		var tick <-chan time.Time
+		if interval > 0 {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		ll.Infof("Bhojpur Deploy configure version %s, watching %s", cmd.Version, strings.Join(args, ", "))
+		apply(ctx, nil)
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case changed, ok := <-changes:
+				if !ok {
+					if ctx.Err() != nil {
+						return nil
+					}
+					return errors.New("stopped watching the directories")
+				}
+				configs := configPaths(changed)
+				if len(configs) == 0 {
+					continue
+				}
+				if full {
+					apply(ctx, nil)
+				} else {
+					apply(ctx, configs)
+				}
+			case <-tick:
+				apply(ctx, nil)
+			case <-hup:
+				ll.Info("Reloading on SIGHUP")
+				apply(ctx, nil)
+			}
+		}
+	},
+}
+
+// configPaths returns the paths of config files among paths
+func configPaths(paths []string) []string {
+	var configs []string
+	for _, p := range paths {
+		if ext := filepath.Ext(p); ext == ".yaml" || ext == ".yml" {
+			configs = append(configs, p)
+		}
+	}
+	return configs
+}
+
+func init() {
+	watchCmd.Flags().Bool("full", false, "Apply all the configs on changes, instead of only the changed ones")
+	watchCmd.Flags().Duration("interval", 0, "Also apply all the configs periodically, 0 to disable it")
+	watchCmd.Flags().Duration("debounce", 2*time.Second, "Time without changes to wait for before applying them")
+	rootCmd.AddCommand(watchCmd)
+}
@@ -21,8 +21,11 @@ package console
 // THE SOFTWARE.
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"os/exec"
+	"syscall"
 
 	"github.com/bhojpur/deploy/pkg/logger"
 	"github.com/hashicorp/go-multierror"
@@ -31,6 +34,7 @@ import (
 
 type StandardConsole struct {
 	logger logger.Interface
+	chroot string
 }
 
 type StandardConsoleOptions func(*StandardConsole) error
@@ -42,6 +46,14 @@ func WithLogger(i logger.Interface) StandardConsoleOptions {
 	}
 }
 
+// WithChroot makes the console run the commands chrooted to root, from its /
+func WithChroot(root string) StandardConsoleOptions {
+	return func(sc *StandardConsole) error {
+		sc.chroot = root
+		return nil
+	}
+}
+
 func NewStandardConsole(opts ...StandardConsoleOptions) *StandardConsole {
 	c := &StandardConsole{
 		logger: logrus.New(),
@@ -54,25 +66,87 @@ func NewStandardConsole(opts ...StandardConsoleOptions) *StandardConsole {
 }
 
 func (s StandardConsole) Run(cmd string, opts ...func(cmd *exec.Cmd)) (string, error) {
+	return s.RunContext(context.Background(), cmd, opts...)
+}
+
+// RunContext runs cmd like Run, killing it if ctx is done before it completes
+func (s StandardConsole) RunContext(ctx context.Context, cmd string, opts ...func(cmd *exec.Cmd)) (string, error) {
 	s.logger.Debugf("running command `%s`", cmd)
 	c := exec.Command("sh", "-c", cmd)
+	if s.chroot != "" {
+		// The shell is looked up in the root, not in the PATH of the host
+		c.Path = "/bin/sh"
+	}
+	s.setChroot(c)
 	for _, o := range opts {
 		o(c)
 	}
-	out, err := c.CombinedOutput()
-	if err != nil {
-		return string(out), fmt.Errorf("failed to run %s: %v", cmd, err)
+	var out bytes.Buffer
+	c.Stdout = &out
+	c.Stderr = &out
+	if err := runContext(ctx, c); err != nil {
+		return out.String(), fmt.Errorf("failed to run %s: %w", cmd, err)
 	}
 
-	return string(out), err
+	return out.String(), nil
 }
 
 func (s StandardConsole) Start(cmd *exec.Cmd, opts ...func(cmd *exec.Cmd)) error {
+	return s.StartContext(context.Background(), cmd, opts...)
+}
+
+// StartContext runs cmd like Start, killing it if ctx is done before it completes
+func (s StandardConsole) StartContext(ctx context.Context, cmd *exec.Cmd, opts ...func(cmd *exec.Cmd)) error {
 	s.logger.Debugf("running command `%s`", cmd)
+	s.setChroot(cmd)
 	for _, o := range opts {
 		o(cmd)
 	}
-	return cmd.Run()
+	return runContext(ctx, cmd)
+}
+
+// setChroot makes c run chrooted to the root of the console, if any
+func (s StandardConsole) setChroot(c *exec.Cmd) {
+	if s.chroot == "" {
+		return
+	}
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Chroot = s.chroot
+	c.Dir = "/"
+}
+
+// runContext runs c until it exits. If ctx is done first, c is killed along
+// with all the processes it spawned, and the error of ctx is returned.
+func runContext(ctx context.Context, c *exec.Cmd) error {
+	if ctx.Done() == nil {
+		return c.Run()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Run the command in its own process group, so children of the shell
+	// are killed too and don't keep its output open
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Setpgid = true
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- c.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+		<-done
+		return ctx.Err()
+	}
 }
 
 func (s StandardConsole) RunTemplate(st []string, template string) error {
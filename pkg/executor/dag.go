@@ -0,0 +1,137 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// sortSteps orders the steps so that every step comes after the steps it
+// depends on. Steps which are not constrained keep their relative order.
+// It fails on duplicated ids, unknown dependencies and dependency cycles.
+func sortSteps(steps []step) ([]step, error) {
+	ids := map[string]int{}
+	for i, s := range steps {
+		if s.ID == "" {
+			continue
+		}
+		if j, ok := ids[s.ID]; ok {
+			return nil, fmt.Errorf("duplicate step id '%s' defined by %s and %s", s.ID, steps[j], s)
+		}
+		ids[s.ID] = i
+	}
+
+	var errs error
+	indegree := make([]int, len(steps))
+	dependents := make([][]int, len(steps))
+	for i, s := range steps {
+		for _, d := range s.DependsOn {
+			j, ok := ids[d]
+			if !ok {
+				errs = multierror.Append(errs, fmt.Errorf("step %s depends on unknown step '%s'", s, d))
+				continue
+			}
+			indegree[i]++
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+	if errs != nil {
+		return nil, errs
+	}
+
+	// Kahn's algorithm, always picking the first defined step among the ready ones
+	ready := []int{}
+	for i := range steps {
+		if indegree[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	sorted := make([]step, 0, len(steps))
+	for len(ready) > 0 {
+		sort.Ints(ready)
+		i := ready[0]
+		ready = ready[1:]
+		sorted = append(sorted, steps[i])
+		for _, j := range dependents[i] {
+			indegree[j]--
+			if indegree[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+
+	if len(sorted) != len(steps) {
+		var cycle []string
+		for i, s := range steps {
+			if indegree[i] > 0 {
+				cycle = append(cycle, s.String())
+			}
+		}
+		return nil, fmt.Errorf("dependency cycle between steps %s", strings.Join(cycle, ", "))
+	}
+	return sorted, nil
+}
+
+// runParallel runs the sorted steps with run, up to e.parallel at a time.
+// A step starts only once all the steps it depends on are done.
+func (e *DefaultExecutor) runParallel(steps []step, run func(step) error) error {
+	done := map[string]chan struct{}{}
+	for _, s := range steps {
+		if s.ID != "" {
+			done[s.ID] = make(chan struct{})
+		}
+	}
+
+	var (
+		errs error
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+	)
+	sem := make(chan struct{}, e.parallel)
+	for _, s := range steps {
+		wg.Add(1)
+		go func(s step) {
+			defer wg.Done()
+			if s.ID != "" {
+				defer close(done[s.ID])
+			}
+			for _, d := range s.DependsOn {
+				<-done[d]
+			}
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if err := run(s); err != nil {
+				mu.Lock()
+				errs = multierror.Append(errs, err)
+				mu.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+
+	return errs
+}
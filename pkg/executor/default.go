@@ -21,10 +21,17 @@ package executor
 // THE SOFTWARE.
 
 import (
+	"context"
 	"encoding/json"
-	"os"
+	"errors"
+	"fmt"
+	"math"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/bhojpur/deploy/pkg/journal"
 	"github.com/bhojpur/deploy/pkg/logger"
 	"github.com/bhojpur/deploy/pkg/plugins"
 	"github.com/bhojpur/deploy/pkg/schema"
@@ -36,146 +43,664 @@ import (
 // DefaultExecutor is the default Bhojpur Deploy Executor.
 // It simply creates file and executes command for a linux executor
 type DefaultExecutor struct {
-	plugins      []Plugin
-	conditionals []Plugin
+	registry     *Registry
+	enabled      []string
+	disabled     []string
+	plugins      []namedPlugin
+	planners     []namedPlanner
+	conditionals []ContextPlugin
 	modifier     schema.Modifier
 	logger       logger.Interface
+	dryRun       bool
+	journal      *journal.Journal
+	events       *events
+	report       *RunReport
+	parallel     int
+	failFast     bool
+	companions   bool
+	vars         map[string]string
+	templateData map[string]interface{}
+	loaded       schema.Loaded
+	lockPath     string
+	lockTimeout  time.Duration
+	redactor     *utils.Redactor
+	sources      []string
+	sourceFS     vfs.FS
+	plan         *Plan
 }
 
+// Plugins replaces the plugins of the executor, see WithPlugins
 func (e *DefaultExecutor) Plugins(p []Plugin) {
-	e.plugins = p
+	r, err := pluginRegistry(p)
+	if err != nil {
+		e.logger.Warnf("Invalid plugins: %s", err.Error())
+		return
+	}
+	e.registry = r
+	e.selectPlugins()
+}
+
+// selectPlugins takes the plugins and the planners from the registry, restricted to
+// the enabled ones if any, and without the disabled ones
+func (e *DefaultExecutor) selectPlugins() {
+	selection := append(append([]string{}, e.enabled...), e.disabled...)
+	if err := e.registry.Validate(selection...); err != nil {
+		e.logger.Warnf("Invalid plugin selection: %s", err.Error())
+	}
+	e.plugins, e.planners = e.registry.selection(e.enabled, e.disabled)
 }
 
 func (e *DefaultExecutor) Conditionals(p []Plugin) {
-	e.conditionals = p
+	e.conditionals = adaptPlugins(p)
 }
 
 func (e *DefaultExecutor) Modifier(m schema.Modifier) {
 	e.modifier = m
 }
 
-func (e *DefaultExecutor) walkDir(stage, dir string, fs vfs.FS, console plugins.Console) error {
-	var errs error
+// Report returns the results of the stages run so far
+func (e *DefaultExecutor) Report() *RunReport {
+	return e.report
+}
 
-	err := vfs.Walk(fs, dir,
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if path == dir {
-				return nil
-			}
-			// Process only files
-			if info.IsDir() {
-				return nil
-			}
-			ext := filepath.Ext(path)
-			if ext != ".yaml" && ext != ".yml" {
-				return nil
-			}
+// Plan returns the changes collected while running in dry-run mode
+func (e *DefaultExecutor) Plan() *Plan {
+	return e.plan
+}
 
-			if err = e.run(stage, path, fs, console, schema.FromFile, e.modifier); err != nil {
-				errs = multierror.Append(errs, err)
-				return nil
-			}
+// source is a Bhojpur Deploy config together with the uri it was loaded from
+type source struct {
+	uri    string
+	config schema.BhojpurConfig
+}
 
-			return nil
-		})
-	if err != nil {
-		errs = multierror.Append(errs, err)
+// step is a single stage step, along with the config and the uri that defined it
+type step struct {
+	schema.Stage
+	config  string
+	uri     string
+	index   int
+	plugins []string
+	vars    map[string]string
+}
+
+// allows tells if the config defining the step lets it run the named plugin
+func (s step) allows(plugin string) bool {
+	return len(s.plugins) == 0 || contains(s.plugins, plugin)
+}
+
+// label returns the id of the step, its name if it has none, or else its
+// position in the config which defines it
+func (s step) label() string {
+	switch {
+	case s.ID != "":
+		return s.ID
+	case s.Name != "":
+		return s.Name
+	default:
+		return fmt.Sprintf("#%d", s.index+1)
 	}
-	return errs
 }
 
-func (e *DefaultExecutor) run(stage, uri string, fs vfs.FS, console plugins.Console, l schema.Loader, m schema.Modifier) error {
-	config, err := schema.Load(uri, fs, l, m)
-	if err != nil {
-		return err
+// context returns the context a single attempt of the step runs with,
+// bounded by the step timeout if it has one
+func (s step) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.Timeout > 0 {
+		return context.WithTimeout(ctx, s.Timeout.Duration())
 	}
+	return context.WithCancel(ctx)
+}
 
-	e.logger.Infof("Executing %s", uri)
-	if err = e.Apply(stage, *config, fs, console); err != nil {
-		return err
+// retryDelay returns how long to wait before retrying the step after the
+// given failed attempt. With a backoff factor the delay grows exponentially.
+func (s step) retryDelay(attempt int) time.Duration {
+	delay := s.RetryDelay.Duration()
+	if s.RetryBackoff > 1 {
+		delay = time.Duration(float64(delay) * math.Pow(s.RetryBackoff, float64(attempt-1)))
 	}
+	return delay
+}
+
+func (s step) String() string {
+	if s.uri != "" {
+		return fmt.Sprintf("'%s' (%s)", s.label(), s.uri)
+	}
+	return fmt.Sprintf("'%s'", s.label())
+}
 
-	return nil
+// load loads the config at uri, unless another config of the run included it already
+func (e *DefaultExecutor) load(uri string, fs vfs.FS, l schema.Loader) (source, error) {
+	config, err := schema.LoadOnce(uri, fs, l, e.modifier, e.loaded)
+	if err != nil {
+		return source{}, err
+	}
+	if config == nil {
+		e.logger.Debugf("Config %s is already loaded, skipping it\n", uri)
+		return source{uri: uri}, nil
+	}
+	return source{uri: uri, config: *config}, nil
 }
 
-func (e *DefaultExecutor) runStage(stage, uri string, fs vfs.FS, console plugins.Console) (err error) {
-	f, err := fs.Stat(uri)
+// loadSources loads the configs from the uris, in order. The directories among them are
+// loaded together, where the first of them is given. Each config is loaded only once, the
+// first time it is given or included.
+func (e *DefaultExecutor) loadSources(uris []string, fs vfs.FS) (sources []source, failedURIs []string, errs []error) {
+	e.loaded = schema.Loaded{}
+	var dirs []string
+	for _, uri := range uris {
+		if f, err := fs.Stat(uri); err == nil && f.IsDir() {
+			dirs = append(dirs, uri)
+		}
+	}
+
+	for _, uri := range uris {
+		if contains(dirs, uri) {
+			if uri == dirs[0] {
+				src, failed, dirErrs := e.loadDirs(dirs, fs)
+				sources = append(sources, src...)
+				failedURIs = append(failedURIs, failed...)
+				errs = append(errs, dirErrs...)
+			}
+			continue
+		}
+		src, err := e.loadSource(uri, fs)
+		if err != nil {
+			failedURIs = append(failedURIs, uri)
+			errs = append(errs, err)
+			continue
+		}
+		sources = append(sources, src)
+	}
+	return sources, failedURIs, errs
+}
 
+// loadSource loads a config from a file, an url, the kernel command line or the config itself
+func (e *DefaultExecutor) loadSource(uri string, fs vfs.FS) (source, error) {
+	var src source
+	_, err := fs.Stat(uri)
 	switch {
-	case err == nil && f.IsDir():
-		err = e.walkDir(stage, uri, fs, console)
+	case schema.IsCmdline(uri):
+		src, err = e.load(uri, fs, schema.FromCmdline)
 	case err == nil:
-		err = e.run(stage, uri, fs, console, schema.FromFile, e.modifier)
+		src, err = e.load(uri, fs, schema.FromFile)
 	case utils.IsUrl(uri):
-		err = e.run(stage, uri, fs, console, schema.FromUrl, e.modifier)
+		src, err = e.load(uri, fs, schema.FromUrl)
 	default:
-
-		err = e.run(stage, uri, fs, console, nil, e.modifier)
+		// Inline config, don't log it as the source
+		src, err = e.load(uri, fs, nil)
+		src.uri = ""
 	}
-
-	return
+	return src, err
 }
 
-// Run takes a list of URI to run deploy files from. URI can be also a dir or a local path, as well as a remote
+// Run takes a list of URI to run deploy files from. URI can be also a dir or a local path, as well as a remote.
+// All the configs are loaded before running any step, so steps from different files can depend on each other.
 func (e *DefaultExecutor) Run(stage string, fs vfs.FS, console plugins.Console, args ...string) error {
+	return e.RunContext(context.Background(), stage, fs, console, args...)
+}
+
+// RunContext is Run, stopping the stage once ctx is done. Steps running at that
+// point are interrupted, and the following ones are skipped.
+func (e *DefaultExecutor) RunContext(ctx context.Context, stage string, fs vfs.FS, console plugins.Console, args ...string) error {
+	return e.RunStages(ctx, []string{stage}, fs, console, args...)
+}
+
+// RunStages is RunContext for several stages, run one after the other. The configs are loaded
+// only once, and shared by all the stages. A step aborting the run stops the following stages too.
+func (e *DefaultExecutor) RunStages(ctx context.Context, stages []string, fs vfs.FS, console plugins.Console, args ...string) error {
+	unlock, err := e.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := e.newTemplateData(); err != nil {
+		return err
+	}
+
+	sourceFS := fs
+	if e.sourceFS != nil {
+		sourceFS = e.sourceFS
+	}
+	sources, failedURIs, loadErrs := e.loadSources(args, sourceFS)
+
 	var errs error
-	e.logger.Infof("Running stage: %s\n", stage)
-	for _, source := range args {
-		if err := e.runStage(stage, source, fs, console); err != nil {
+	stages = e.expandStages(stages)
+	for i, stage := range stages {
+		err := e.withStageEvents(stage, func() error {
+			var errs error
+			e.logger.Infof("Running stage: %s\n", stage)
+			// Loading errors are reported once, with the first stage
+			if i == 0 {
+				for j, err := range loadErrs {
+					e.events.emit(Event{Type: EventError, Stage: stage, Source: failedURIs[j], Error: err.Error()})
+					errs = multierror.Append(errs, err)
+				}
+			}
+
+			if err := e.applySources(ctx, stage, sources, fs, console); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+			e.logger.Infof("Done executing stage '%s'\n", stage)
+			return errs
+		})
+		if err != nil {
 			errs = multierror.Append(errs, err)
 		}
+
+		var stepErr *StepError
+		if (errors.As(err, &stepErr) && stepErr.Aborted()) || ctx.Err() != nil {
+			if i < len(stages)-1 {
+				e.logger.Warnf("Not running the remaining stages: %s\n", strings.Join(stages[i+1:], ", "))
+			}
+			break
+		}
 	}
-	e.logger.Infof("Done executing stage '%s'\n", stage)
 	return errs
 }
 
+// SplitStages returns the stages of a comma separated list, without the spaces around
+// them and skipping the empty ones
+func SplitStages(list string) []string {
+	var stages []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			stages = append(stages, s)
+		}
+	}
+	return stages
+}
+
+// expandStages adds the .before and .after companions around each stage, if enabled
+func (e *DefaultExecutor) expandStages(stages []string) []string {
+	if !e.companions {
+		return stages
+	}
+	expanded := make([]string, 0, 3*len(stages))
+	for _, s := range stages {
+		expanded = append(expanded, s+".before", s, s+".after")
+	}
+	return expanded
+}
+
 // Apply applies a Bhojpur Deploy Config file by creating files and running commands defined.
 func (e *DefaultExecutor) Apply(stageName string, s schema.BhojpurConfig, fs vfs.FS, console plugins.Console) error {
-	currentStages := s.Stages[stageName]
-	if len(currentStages) == 0 {
-		e.logger.Debugf("No commands to run for %s %s\n", stageName, s.Name)
+	return e.ApplyContext(context.Background(), stageName, s, fs, console)
+}
+
+// ApplyContext is Apply, stopping the stage once ctx is done
+func (e *DefaultExecutor) ApplyContext(ctx context.Context, stageName string, s schema.BhojpurConfig, fs vfs.FS, console plugins.Console) error {
+	unlock, err := e.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := e.newTemplateData(); err != nil {
+		return err
+	}
+
+	return e.withStageEvents(stageName, func() error {
+		return e.applySources(ctx, stageName, []source{{config: s}}, fs, console)
+	})
+}
+
+// withStageEvents runs the stage with f, emitting the events about it
+func (e *DefaultExecutor) withStageEvents(stageName string, f func() error) error {
+	start := time.Now()
+	e.events.emit(Event{Type: EventStageStarted, Stage: stageName, Time: start})
+	err := f()
+	e.events.emit(Event{Type: EventStageFinished, Stage: stageName, Duration: time.Since(start), Error: errorString(err)})
+	return err
+}
+
+func (e *DefaultExecutor) applySources(ctx context.Context, stageName string, sources []source, fs vfs.FS, console plugins.Console) error {
+	var steps []step
+	for _, src := range sources {
+		currentStages := src.config.Stages[stageName]
+		if len(currentStages) == 0 {
+			e.logger.Debugf("No commands to run for %s %s\n", stageName, src.config.Name)
+			continue
+		}
+		if src.uri != "" {
+			e.logger.Infof("Loaded %s", src.uri)
+		}
+		e.logger.Infof("Applying '%s' for stage '%s'. Total stages: %d\n", src.config.Name, stageName, len(currentStages))
+		for i, st := range currentStages {
+			steps = append(steps, step{Stage: st, config: src.config.Name, uri: src.uri, index: i, plugins: src.config.Plugins, vars: src.config.Vars})
+		}
+	}
+	if len(steps) == 0 {
 		return nil
 	}
 
-	e.logger.Infof("Applying '%s' for stage '%s'. Total stages: %d\n", s.Name, stageName, len(currentStages))
+	e.setVars(steps)
+	for _, st := range steps {
+		e.redactor.Add(st.Secrets()...)
+	}
+	err := checkPolicies(steps)
+	if err == nil {
+		err = e.checkPlugins(steps)
+	}
+	if err == nil {
+		steps, err = sortSteps(steps)
+	}
+	if err == nil && e.sources != nil {
+		steps = e.selectSources(steps)
+	}
+	if err != nil {
+		e.logger.Errorf("Refusing to run stage '%s': %s", stageName, err.Error())
+		e.events.emit(Event{Type: EventError, Stage: stageName, Error: err.Error()})
+		return err
+	}
 
-	var errs error
-STAGES:
-	for _, stage := range currentStages {
-		for _, p := range e.conditionals {
-			if err := p(e.logger, stage, fs, console); err != nil {
-				e.logger.Warnf("Error '%s' in stage name: %s stage: %s\n",
-					err.Error(), s.Name, stageName)
-				continue STAGES
-			}
+	// Failing steps can stop the stage depending on their on_failure policy.
+	// Aborting also interrupts the steps still running.
+	stageCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		mu      sync.Mutex
+		stopped *StepError
+	)
+	run := func(stage step) error {
+		mu.Lock()
+		by := stopped
+		mu.Unlock()
+		if by != nil {
+			e.skip(stageName, stage, fmt.Sprintf("stage stopped by step '%s'", by.Step))
+			return nil
 		}
 
-		e.logger.Infof(
-			"Processing stage step '%s'. ( commands: %d, files: %d, ... )\n",
-			stage.Name,
-			len(stage.Commands),
-			len(stage.Files))
+		err := e.runStep(stageCtx, stageName, stage, fs, console)
+		policy := e.failurePolicy(stage)
+		if err == nil || policy == schema.OnFailureContinue {
+			return err
+		}
 
-		b, _ := json.Marshal(stage)
-		e.logger.Debugf("Stage: %s", string(b))
+		se := &StepError{Stage: stageName, Step: stage.label(), Source: stage.uri, Policy: policy, Err: err}
+		mu.Lock()
+		if stopped == nil {
+			stopped = se
+			if policy == schema.OnFailureAbort {
+				e.logger.Errorf("Step %s failed, aborting\n", stage)
+			} else {
+				e.logger.Errorf("Step %s failed, skipping the remaining steps of stage '%s'\n", stage, stageName)
+			}
+		}
+		mu.Unlock()
+		if policy == schema.OnFailureAbort {
+			cancel()
+		}
+		return se
+	}
 
-		for _, p := range e.plugins {
-			if err := p(e.logger, stage, fs, console); err != nil {
-				e.logger.Error(err.Error())
+	var errs error
+	if e.parallel > 1 {
+		errs = e.runParallel(steps, run)
+	} else {
+		for _, stage := range steps {
+			if err := run(stage); err != nil {
 				errs = multierror.Append(errs, err)
 			}
 		}
 	}
+	if err := ctx.Err(); err != nil {
+		e.logger.Errorf("Stage '%s' interrupted: %s", stageName, err.Error())
+		errs = multierror.Append(errs, fmt.Errorf("stage '%s' interrupted: %w", stageName, err))
+	}
 
 	e.logger.Infof(
 		"Stage '%s'. Defined stages: %d. Errors: %t\n",
 		stageName,
-		len(currentStages),
+		len(steps),
 		errs != nil,
 	)
 
 	return errs
 }
+
+// selectSources keeps the sorted steps of the sources selected for the run. The steps they
+// depend on in the other sources are taken as already applied.
+func (e *DefaultExecutor) selectSources(steps []step) []step {
+	selected := map[string]bool{}
+	for _, uri := range e.sources {
+		selected[filepath.Clean(uri)] = true
+	}
+
+	var kept []step
+	ids := map[string]bool{}
+	for _, s := range steps {
+		if s.uri == "" || !selected[filepath.Clean(s.uri)] {
+			continue
+		}
+		kept = append(kept, s)
+		if s.ID != "" {
+			ids[s.ID] = true
+		}
+	}
+	for i, s := range kept {
+		var deps []string
+		for _, d := range s.DependsOn {
+			if ids[d] {
+				deps = append(deps, d)
+			}
+		}
+		kept[i].DependsOn = deps
+	}
+	return kept
+}
+
+// runStep applies a single stage step, unless one of the conditionals skips it
+func (e *DefaultExecutor) runStep(ctx context.Context, stageName string, stage step, fs vfs.FS, console plugins.Console) error {
+	l := e.stepLogger(stage)
+	if err := ctx.Err(); err != nil {
+		e.skip(stageName, stage, err.Error())
+		return nil
+	}
+
+	for _, p := range e.conditionals {
+		cctx, cancel := stage.context(ctx)
+		err := p(cctx, l, stage.Stage, fs, plugins.BindConsole(cctx, console))
+		cancel()
+		if err != nil {
+			l.Warnf("Error '%s' in stage name: %s stage: %s\n",
+				err.Error(), stage.config, stageName)
+			ev := stepEvent(EventStepSkipped, stageName, stage)
+			ev.Reason = err.Error()
+			e.events.emit(ev)
+			return nil
+		}
+	}
+
+	if reason := e.upToDate(stageName, stage); reason != "" {
+		e.skip(stageName, stage, reason)
+		return nil
+	}
+
+	l.Infof(
+		"Processing stage step '%s'. ( commands: %d, files: %d, ... )\n",
+		stage.Name,
+		len(stage.Commands),
+		len(stage.Files))
+
+	start := time.Now()
+	e.events.emit(stepEvent(EventStepStarted, stageName, stage))
+
+	rendered, err := e.render(l, stage)
+	if err != nil {
+		l.Error(err.Error())
+		if e.dryRun {
+			e.plan.add(StepPlan{Stage: stageName, Config: stage.config, Source: stage.uri, Step: stage.Name, Changes: []plugins.Change{}, Errors: []string{err.Error()}})
+		}
+	} else {
+		e.redactor.Add(rendered.Secrets()...)
+		b, _ := json.Marshal(rendered.Stage.Redacted())
+		l.Debugf("Stage: %s", string(b))
+
+		if e.dryRun {
+			err = e.planStage(l, stageName, rendered, fs, console)
+		} else {
+			err = e.runAttempts(ctx, l, stageName, rendered, fs, console)
+		}
+	}
+	// The journal keeps the hash of the step as defined, so that it doesn't
+	// change with the data of the templates, e.g. .UUID
+	if !e.dryRun {
+		e.record(l, stageName, stage, err)
+	}
+
+	ev := stepEvent(EventStepFinished, stageName, stage)
+	ev.Duration = time.Since(start)
+	ev.Error = errorString(err)
+	e.events.emit(ev)
+	return err
+}
+
+// skip logs that the step is not run, and why
+func (e *DefaultExecutor) skip(stageName string, stage step, reason string) {
+	e.stepLogger(stage).Infof("Skipping stage step %s: %s\n", stage, reason)
+	ev := stepEvent(EventStepSkipped, stageName, stage)
+	ev.Reason = reason
+	e.events.emit(ev)
+}
+
+// runAttempts runs the plugins for the step, retrying as the step defines
+func (e *DefaultExecutor) runAttempts(ctx context.Context, l logger.Interface, stageName string, stage step, fs vfs.FS, console plugins.Console) error {
+	attempts := stage.Retries + 1
+	if attempts < 1 {
+		attempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		if attempts > 1 {
+			l.Infof("Running stage step %s, attempt %d/%d\n", stage, attempt, attempts)
+		}
+		err := e.runPlugins(ctx, l, stageName, stage, attempt, fs, console)
+		if err == nil || attempt == attempts || ctx.Err() != nil {
+			if err != nil && attempts > 1 {
+				l.Errorf("Stage step %s failed after %d attempt(s)\n", stage, attempt)
+			}
+			return err
+		}
+
+		delay := stage.retryDelay(attempt)
+		l.Warnf("Stage step %s failed, retrying in %s (attempt %d/%d)\n", stage, delay, attempt, attempts)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// runPlugins runs all the plugins for a single attempt of a stage step
+func (e *DefaultExecutor) runPlugins(ctx context.Context, l logger.Interface, stageName string, stage step, attempt int, fs vfs.FS, console plugins.Console) error {
+	parent := ctx
+	ctx, cancel := stage.context(ctx)
+	defer cancel()
+	console = plugins.BindConsole(ctx, console)
+
+	var snap *snapshot
+	if stage.Transactional {
+		var err error
+		if snap, err = e.takeSnapshot(l, stage, fs, console); err != nil {
+			err = fmt.Errorf("not running transactional step %s, taking a snapshot failed: %w", stage, err)
+			l.Error(err.Error())
+			return err
+		}
+	}
+
+	var errs error
+	for _, p := range e.plugins {
+		if ctx.Err() != nil {
+			break
+		}
+		if !stage.allows(p.name) {
+			continue
+		}
+		e.events.emit(pluginEvent(EventPluginStarted, stageName, stage, p.name, attempt))
+		start := time.Now()
+		err := p.plugin(ctx, l, stage.Stage, fs, console)
+
+		finished := pluginEvent(EventPluginFinished, stageName, stage, p.name, attempt)
+		finished.Duration = time.Since(start)
+		finished.Error = errorString(err)
+		e.events.emit(finished)
+		if err != nil {
+			l.Error(err.Error())
+			failed := pluginEvent(EventError, stageName, stage, p.name, attempt)
+			failed.Error = err.Error()
+			e.events.emit(failed)
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if ctx.Err() == context.DeadlineExceeded && parent.Err() == nil {
+		err := fmt.Errorf("step %s timed out after %s", stage, stage.Timeout)
+		l.Error(err.Error())
+		errs = multierror.Append(errs, err)
+	}
+	if errs != nil && snap != nil {
+		l.Warnf("Rolling back the files changed by step %s\n", stage)
+		if err := snap.restore(); err != nil {
+			l.Error(err.Error())
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+// checkPlugins makes sure the configs of the steps allow only plugins known to the executor.
+// Allowing a plugin doesn't enable it, if it was disabled.
+func (e *DefaultExecutor) checkPlugins(steps []step) error {
+	known := map[string]bool{}
+	for _, n := range e.registry.Names() {
+		known[n] = true
+	}
+
+	var errs error
+	checked := map[string]bool{}
+	for _, s := range steps {
+		key := s.uri + "/" + s.config
+		if checked[key] {
+			continue
+		}
+		checked[key] = true
+		for _, p := range s.plugins {
+			if !known[p] {
+				errs = multierror.Append(errs, fmt.Errorf("config '%s' %s allows unknown plugin '%s'", s.config, s.uri, p))
+			}
+		}
+	}
+	return errs
+}
+
+// stepLogger returns the logger for a stage step. When steps run in parallel
+// their output interleaves, so each line is prefixed with the step it belongs to.
+func (e *DefaultExecutor) stepLogger(stage step) logger.Interface {
+	if e.parallel <= 1 {
+		return e.logger
+	}
+	return logger.WithPrefix(e.logger, fmt.Sprintf("[%s] ", stage.label()))
+}
+
+// planStage collects the changes the planners report for a stage step
+func (e *DefaultExecutor) planStage(l logger.Interface, stageName string, stage step, fs vfs.FS, console plugins.Console) error {
+	var errs error
+	sp := StepPlan{Stage: stageName, Config: stage.config, Source: stage.uri, Step: stage.Name, Changes: []plugins.Change{}}
+	for _, p := range e.planners {
+		if !stage.allows(p.name) {
+			continue
+		}
+		changes, err := p.planner(l, stage.Stage, fs, console)
+		for i := range changes {
+			changes[i].Target = e.redactor.Redact(changes[i].Target)
+			changes[i].Detail = e.redactor.Redact(changes[i].Detail)
+			changes[i].Diff = e.redactor.Redact(changes[i].Diff)
+		}
+		if err != nil {
+			l.Error(err.Error())
+			sp.Errors = append(sp.Errors, err.Error())
+			errs = multierror.Append(errs, err)
+		}
+		sp.Changes = append(sp.Changes, changes...)
+	}
+	e.plan.add(sp)
+	return errs
+}
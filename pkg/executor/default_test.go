@@ -21,16 +21,29 @@ package executor_test
 // THE SOFTWARE.
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/bhojpur/deploy/pkg/console"
 	"github.com/sirupsen/logrus"
 
 	. "github.com/bhojpur/deploy/pkg/executor"
+	"github.com/bhojpur/deploy/pkg/journal"
+	"github.com/bhojpur/deploy/pkg/logger"
+	"github.com/bhojpur/deploy/pkg/plugins"
 	"github.com/bhojpur/deploy/pkg/schema"
 	consoletests "github.com/bhojpur/deploy/tests/console"
+	"github.com/twpayne/go-vfs"
 	"github.com/twpayne/go-vfs/vfst"
 	"github.com/zcalusic/sysinfo"
 
@@ -365,6 +378,1061 @@ users: "one,two,tree"
 			Expect(string(b)).Should(Equal("nm-openconnect:x:979:\n"))
 		})
 
+		It("Reports changes without applying them in dry-run mode", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/tmp/test/bar": "boo"})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			dry := NewExecutor(WithLogger(logrus.New()), WithDryRun(true))
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {{
+					Name:     "dry",
+					Commands: []string{"echo foo"},
+					Files: []schema.File{
+						{Path: "/tmp/test/foo", Content: "foo", Permissions: 0644},
+						{Path: "/tmp/test/bar", Content: "bar", Permissions: 0644},
+					},
+				}},
+			}}
+
+			err = dry.Apply("foo", config, fs, testConsole)
+			Expect(err).ShouldNot(HaveOccurred())
+
+			_, err = fs.Stat("/tmp/test/foo")
+			Expect(err).Should(HaveOccurred())
+			b, err := fs.ReadFile("/tmp/test/bar")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).Should(Equal("boo"))
+
+			plan := dry.Plan()
+			Expect(len(plan.Steps)).To(Equal(1))
+			Expect(plan.Steps[0].Step).To(Equal("dry"))
+			Expect(plan.Steps[0].Changes).To(ContainElement(plugins.Change{
+				Plugin: "commands", Kind: plugins.KindCommand, Action: plugins.ActionRun, Target: "echo foo",
+			}))
+
+			var targets []string
+			for _, c := range plan.Steps[0].Changes {
+				targets = append(targets, c.Action+" "+c.Target)
+			}
+			Expect(targets).To(ContainElements("create /tmp/test/foo", "update /tmp/test/bar"))
+
+			out := &bytes.Buffer{}
+			Expect(plan.WriteText(out)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("-boo"))
+			Expect(out.String()).To(ContainSubstring("+bar"))
+		})
+
+		It("Orders steps by depends_on across deploy files", func() {
+			testConsole := console.NewStandardConsole()
+
+			fs2, cleanup2, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			temp := fs2.TempDir()
+
+			defer cleanup2()
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/deploy/01_first.yaml": `
+stages:
+  test:
+  - id: second
+    depends_on: [ first ]
+    commands:
+    - echo -n "second" >> ` + temp + `/tmp/test/bar
+`,
+				"/some/deploy/02_second.yaml": `
+stages:
+  test:
+  - id: first
+    commands:
+    - echo -n "first " >> ` + temp + `/tmp/test/bar
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			err = fs2.Mkdir("/tmp", os.ModePerm)
+			Expect(err).Should(BeNil())
+			err = fs2.Mkdir("/tmp/test", os.ModePerm)
+			Expect(err).Should(BeNil())
+
+			err = def.Run("test", fs, testConsole, "/some/deploy")
+			Expect(err).Should(BeNil())
+
+			b, err := ioutil.ReadFile(temp + "/tmp/test/bar")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).Should(Equal("first second"))
+		})
+
+		It("Refuses to run a stage with unknown dependencies", func() {
+			consoletests.Reset()
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/deploy/01_first.yaml": `
+stages:
+  test:
+  - id: first
+    commands:
+    - echo first
+  - id: second
+    depends_on: [ missing ]
+    commands:
+    - echo second
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			err = def.Run("test", fs, testConsole, "/some/deploy")
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).Should(ContainSubstring("unknown step 'missing'"))
+			Expect(consoletests.Commands).Should(BeEmpty())
+		})
+
+		It("Detects dependency cycles", func() {
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {
+					{ID: "a", DependsOn: []string{"b"}, Commands: []string{"echo a"}},
+					{ID: "b", DependsOn: []string{"a"}, Commands: []string{"echo b"}},
+					{ID: "c", Commands: []string{"echo c"}},
+				},
+			}}
+
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			consoletests.Reset()
+			err = def.Apply("foo", config, fs, testConsole)
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).Should(ContainSubstring("dependency cycle between steps 'a', 'b'"))
+			Expect(consoletests.Commands).Should(BeEmpty())
+		})
+
+		It("Runs independent steps in parallel, honoring depends_on", func() {
+			testConsole := console.NewStandardConsole()
+
+			fs2, cleanup2, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			temp := fs2.TempDir()
+
+			defer cleanup2()
+
+			err = fs2.Mkdir("/tmp", os.ModePerm)
+			Expect(err).Should(BeNil())
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {
+					{ID: "second", DependsOn: []string{"first"}, Commands: []string{"echo -n ' second' >> " + temp + "/tmp/bar"}},
+					{ID: "first", Commands: []string{"sleep 0.2", "echo -n 'first' >> " + temp + "/tmp/bar"}},
+					{ID: "other", Commands: []string{"echo -n 'other' > " + temp + "/tmp/baz"}},
+					{ID: "failing", Commands: []string{"exit 1"}},
+				},
+			}}
+
+			parallel := NewExecutor(WithLogger(logrus.New()), WithParallel(4))
+			err = parallel.Apply("foo", config, fs2, testConsole)
+			Expect(err).Should(HaveOccurred())
+
+			b, err := ioutil.ReadFile(temp + "/tmp/bar")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).Should(Equal("first second"))
+
+			b, err = ioutil.ReadFile(temp + "/tmp/baz")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).Should(Equal("other"))
+		})
+
+		It("Stops steps exceeding their timeout", func() {
+			testConsole := console.NewStandardConsole()
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {
+					{Name: "slow", Timeout: schema.Duration(100 * time.Millisecond), Commands: []string{"sleep 10"}},
+					{Name: "fast", Commands: []string{"echo -n 'fast' > " + fs.TempDir() + "/fast"}},
+				},
+			}}
+
+			start := time.Now()
+			err = def.Apply("foo", config, fs, testConsole)
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).Should(ContainSubstring("step 'slow' timed out after 100ms"))
+			Expect(time.Since(start)).Should(BeNumerically("<", 5*time.Second))
+
+			b, err := fs.ReadFile("/fast")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).Should(Equal("fast"))
+		})
+
+		It("Skips steps once the context is done", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {{Commands: []string{"echo foo"}}},
+			}}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			consoletests.Reset()
+			err = def.ApplyContext(ctx, "foo", config, fs, testConsole)
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).Should(ContainSubstring("stage 'foo' interrupted"))
+			Expect(consoletests.Commands).Should(BeEmpty())
+		})
+
+		It("Retries failing steps", func() {
+			testConsole := console.NewStandardConsole()
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+			temp := fs.TempDir()
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {
+					{
+						Name:         "flaky",
+						Retries:      3,
+						RetryDelay:   schema.Duration(10 * time.Millisecond),
+						RetryBackoff: 2,
+						Commands:     []string{"echo -n x >> " + temp + "/flaky", "test $(cat " + temp + "/flaky) = xx"},
+					},
+					{
+						Name:       "broken",
+						Retries:    2,
+						RetryDelay: schema.Duration(10 * time.Millisecond),
+						Commands:   []string{"echo -n x >> " + temp + "/broken", "exit 1"},
+					},
+				},
+			}}
+
+			err = def.Apply("foo", config, fs, testConsole)
+			Expect(err).Should(HaveOccurred())
+
+			b, err := fs.ReadFile("/flaky")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).Should(Equal("xx"))
+
+			b, err = fs.ReadFile("/broken")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(b)).Should(Equal("xxx"))
+		})
+
+		It("Stops the stage according to on_failure", func() {
+			testConsole := console.NewStandardConsole()
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+			temp := fs.TempDir()
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {
+					{Name: "tolerated", OnFailure: schema.OnFailureContinue, Commands: []string{"exit 1"}},
+					{Name: "layout", OnFailure: schema.OnFailureSkipRemaining, Commands: []string{"exit 1"}},
+					{Name: "files", Commands: []string{"touch " + temp + "/files"}},
+				},
+			}}
+
+			err = def.Apply("foo", config, fs, testConsole)
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).Should(ContainSubstring("stage 'foo' stopped by step 'layout'"))
+
+			var stepErr *StepError
+			Expect(errors.As(err, &stepErr)).To(BeTrue())
+			Expect(stepErr.Step).To(Equal("layout"))
+			Expect(stepErr.Aborted()).To(BeFalse())
+
+			_, err = fs.Stat("/files")
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("Aborts at the first failing step with fail-fast", func() {
+			testConsole := console.NewStandardConsole()
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+			temp := fs.TempDir()
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {
+					{Name: "first", Commands: []string{"exit 1"}},
+					{Name: "second", Commands: []string{"touch " + temp + "/second"}},
+				},
+			}}
+
+			failFast := NewExecutor(WithLogger(logrus.New()), WithFailFast(true))
+			err = failFast.Apply("foo", config, fs, testConsole)
+			Expect(err).Should(HaveOccurred())
+
+			var stepErr *StepError
+			Expect(errors.As(err, &stepErr)).To(BeTrue())
+			Expect(stepErr.Step).To(Equal("first"))
+			Expect(stepErr.Aborted()).To(BeTrue())
+
+			_, err = fs.Stat("/second")
+			Expect(err).Should(HaveOccurred())
+
+			config.Stages["foo"][0].OnFailure = schema.OnFailureContinue
+			err = failFast.Apply("foo", config, fs, testConsole)
+			Expect(err).Should(HaveOccurred())
+			Expect(errors.As(err, &stepErr)).To(BeFalse())
+			_, err = fs.Stat("/second")
+			Expect(err).ShouldNot(HaveOccurred())
+
+			config.Stages["foo"][0].OnFailure = "explode"
+			err = failFast.Apply("foo", config, fs, testConsole)
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).Should(ContainSubstring("invalid on_failure policy 'explode'"))
+		})
+
+		It("Refuses negative retries", func() {
+			testConsole := console.NewStandardConsole()
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+			temp := fs.TempDir()
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {{Name: "endless", Retries: -1, Commands: []string{"echo -n x >> " + temp + "/endless", "exit 1"}}},
+			}}
+			err = def.Apply("foo", config, fs, testConsole)
+			Expect(err).Should(HaveOccurred())
+			Expect(err.Error()).Should(ContainSubstring("step 'endless' has negative retries"))
+			_, err = fs.Stat("/endless")
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("Rolls back the files changed by failing transactional steps", func() {
+			testConsole := console.NewStandardConsole()
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/etc/app.conf": &vfst.File{Contents: []byte("old"), Perm: 0600},
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {{
+					Name:          "broken",
+					Transactional: true,
+					Files: []schema.File{
+						{Path: "/etc/app.conf", Content: "new", Permissions: 0644},
+						{Path: "/etc/app.d/extra.conf", Content: "extra", Permissions: 0644},
+					},
+					Commands: []string{"exit 1"},
+				}},
+			}}
+
+			Expect(def.Apply("foo", config, fs, testConsole)).ToNot(Succeed())
+			content, err := fs.ReadFile("/etc/app.conf")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal("old"))
+			info, err := fs.Stat("/etc/app.conf")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+			_, err = fs.Stat("/etc/app.d")
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			config.Stages["foo"][0].Transactional = false
+			Expect(def.Apply("foo", config, fs, testConsole)).ToNot(Succeed())
+			content, err = fs.ReadFile("/etc/app.conf")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal("new"))
+			_, err = fs.Stat("/etc/app.d/extra.conf")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Rolls back the users added by failing transactional steps", func() {
+			testConsole := console.NewStandardConsole()
+			entityFiles := map[string]string{
+				"/etc/passwd": "root:x:0:0:root:/root:/bin/sh\n",
+				"/etc/shadow": "root:!:18000::::::\n",
+				"/etc/group":  "root:x:0:\n",
+			}
+			initial := map[string]interface{}{}
+			for path, content := range entityFiles {
+				initial[path] = content
+			}
+			fs, cleanup, err := vfst.NewTestFS(initial)
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {{
+					Name:          "broken",
+					Transactional: true,
+					Users:         map[string]schema.User{"depcfg-rollback": {NoCreateHome: true}},
+					Commands:      []string{"exit 1"},
+				}},
+			}}
+
+			Expect(def.Apply("foo", config, fs, testConsole)).ToNot(Succeed())
+			for path, content := range entityFiles {
+				Expect(fs.ReadFile(path)).To(Equal([]byte(content)), path)
+			}
+
+			config.Stages["foo"][0].Transactional = false
+			Expect(def.Apply("foo", config, fs, testConsole)).ToNot(Succeed())
+			passwd, err := fs.ReadFile("/etc/passwd")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(passwd)).To(ContainSubstring("depcfg-rollback"))
+		})
+
+		It("Waits for the run lock held by another run", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/run/depcfg.lock": "4242\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			path, err := fs.RawPath("/run/depcfg.lock")
+			Expect(err).ToNot(HaveOccurred())
+			held, err := os.OpenFile(path, os.O_RDWR, 0644)
+			Expect(err).ToNot(HaveOccurred())
+			defer held.Close()
+			Expect(syscall.Flock(int(held.Fd()), syscall.LOCK_EX)).To(Succeed())
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {{Commands: []string{"echo locked"}}},
+			}}
+			locked := NewExecutor(WithLogger(logrus.New()), WithLock(path, 300*time.Millisecond))
+			consoletests.Reset()
+			start := time.Now()
+			err = locked.Apply("foo", config, fs, testConsole)
+			Expect(err).To(MatchError(ContainSubstring("another run (PID 4242) holds the lock " + path)))
+			Expect(time.Since(start)).To(BeNumerically(">=", 300*time.Millisecond))
+			Expect(consoletests.Commands).To(BeEmpty())
+
+			go func() {
+				defer GinkgoRecover()
+				time.Sleep(200 * time.Millisecond)
+				Expect(syscall.Flock(int(held.Fd()), syscall.LOCK_UN)).To(Succeed())
+			}()
+			locked = NewExecutor(WithLogger(logrus.New()), WithLock(path, 5*time.Second))
+			Expect(locked.Apply("foo", config, fs, testConsole)).To(Succeed())
+			Expect(consoletests.Commands).To(Equal([]string{"echo locked"}))
+		})
+
+		It("Runs without the lock when the lock file can't be created", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/run": "not a directory"})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			path, err := fs.RawPath("/run/depcfg.lock")
+			Expect(err).ToNot(HaveOccurred())
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {{Commands: []string{"echo unlocked"}}},
+			}}
+			out := &bytes.Buffer{}
+			l := logrus.New()
+			l.SetOutput(out)
+			unlocked := NewExecutor(WithLogger(l), WithLock(path, time.Second))
+			consoletests.Reset()
+			Expect(unlocked.Apply("foo", config, fs, testConsole)).To(Succeed())
+			Expect(consoletests.Commands).To(Equal([]string{"echo unlocked"}))
+			Expect(out.String()).To(ContainSubstring("Running without the lock " + path))
+		})
+
+		It("Doesn't create the run lock in dry-run", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/run": &vfst.Dir{Perm: 0755}})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			path, err := fs.RawPath("/run/depcfg.lock")
+			Expect(err).ToNot(HaveOccurred())
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {{Commands: []string{"echo locked"}}},
+			}}
+			dry := NewExecutor(WithLogger(logrus.New()), WithLock(path, time.Second), WithDryRun(true))
+			consoletests.Reset()
+			Expect(dry.Apply("foo", config, fs, testConsole)).To(Succeed())
+			Expect(consoletests.Commands).To(BeEmpty())
+			_, err = os.Stat(path)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+
+		It("Skips steps already applied according to the journal", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			j, err := journal.Load("/var/lib/depcfg/journal.json", fs)
+			Expect(err).ShouldNot(HaveOccurred())
+			journaled := NewExecutor(WithLogger(logrus.New()), WithJournal(j))
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {
+					{ID: "once", Once: true, Commands: []string{"echo once"}},
+					{ID: "changed", Run: schema.RunOnChange, Commands: []string{"echo changed"}},
+					{ID: "always", Commands: []string{"echo always"}},
+				},
+			}}
+
+			consoletests.Reset()
+			Expect(journaled.Apply("foo", config, fs, testConsole)).To(Succeed())
+			Expect(consoletests.Commands).To(Equal([]string{"echo once", "echo changed", "echo always"}))
+
+			consoletests.Reset()
+			Expect(journaled.Apply("foo", config, fs, testConsole)).To(Succeed())
+			Expect(consoletests.Commands).To(Equal([]string{"echo always"}))
+
+			config.Stages["foo"][0].Commands = []string{"echo once again"}
+			config.Stages["foo"][1].Commands = []string{"echo changed again"}
+			consoletests.Reset()
+			Expect(journaled.Apply("foo", config, fs, testConsole)).To(Succeed())
+			Expect(consoletests.Commands).To(Equal([]string{"echo changed again", "echo always"}))
+
+			j, err = journal.Load("/var/lib/depcfg/journal.json", fs)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(j.Keys()).To(Equal([]string{"foo/changed", "foo/once"}))
+			e, _ := j.Get("foo/changed")
+			Expect(e.Succeeded()).To(BeTrue())
+		})
+
+		It("Emits events to the subscribed handlers", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			var events []Event
+			out := &bytes.Buffer{}
+			subscribed := NewExecutor(
+				WithLogger(logrus.New()),
+				WithEventHandler(func(e Event) { events = append(events, e) }),
+				WithEventHandler(JSONLinesHandler(out)),
+				WithPlugins(plugins.Commands),
+			)
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {
+					{Name: "skipped", Node: "not-this-node", Commands: []string{"echo skipped"}},
+					{Name: "run", Commands: []string{"echo run"}},
+				},
+			}}
+			Expect(subscribed.Apply("foo", config, fs, testConsole)).To(Succeed())
+
+			var types []string
+			for _, e := range events {
+				types = append(types, e.Type+" "+e.Step+" "+e.Plugin)
+			}
+			Expect(types).To(Equal([]string{
+				"stage_started  ",
+				"step_skipped skipped ",
+				"step_started run ",
+				"plugin_started run Commands",
+				"plugin_finished run Commands",
+				"step_finished run ",
+				"stage_finished  ",
+			}))
+			Expect(events[1].Reason).ToNot(BeEmpty())
+			Expect(strings.Count(out.String(), "\n")).To(Equal(len(events)))
+		})
+
+		It("Reports the result of each step", func() {
+			testConsole := console.NewStandardConsole()
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/deploy/01_first.yaml": `
+stages:
+  test:
+  - name: skipped
+    node: not-this-node
+  - name: broken
+    commands:
+    - exit 1
+  - name: working
+    commands:
+    - "true"
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			reporting := NewExecutor(WithLogger(logrus.New()), WithPlugins(plugins.Commands))
+			err = reporting.Run("test", fs, testConsole, "/some/deploy")
+			Expect(err).Should(HaveOccurred())
+
+			report := reporting.Report()
+			Expect(report.Failed()).To(BeTrue())
+			Expect(len(report.Stages)).To(Equal(1))
+			Expect(len(report.Stages[0].Sources)).To(Equal(1))
+			Expect(report.Stages[0].Sources[0].Source).To(Equal("/some/deploy/01_first.yaml"))
+
+			steps := report.Stages[0].Sources[0].Steps
+			Expect(len(steps)).To(Equal(3))
+			Expect(steps[0].Status).To(Equal(StatusSkipped))
+			Expect(steps[1].Status).To(Equal(StatusFailure))
+			Expect(steps[1].Error).To(ContainSubstring("exit status 1"))
+			Expect(steps[1].Plugins[0].Name).To(Equal("Commands"))
+			Expect(steps[1].Plugins[0].Status).To(Equal(StatusFailure))
+			Expect(steps[2].Status).To(Equal(StatusSuccess))
+
+			out := &bytes.Buffer{}
+			Expect(report.WriteJUnit(out)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring(`tests="3" failures="1" errors="0" skipped="1"`))
+			Expect(out.String()).To(ContainSubstring(`<testcase name="broken" classname="test"`))
+		})
+
+		It("Writes the metrics of the run", func() {
+			testConsole := console.NewStandardConsole()
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/deploy/01_first.yaml": `
+stages:
+  test:
+  - name: skipped
+    node: not-this-node
+  - name: broken
+    commands:
+    - exit 1
+  - name: working
+    commands:
+    - "true"
+  other:
+  - commands:
+    - "true"
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			measured := NewExecutor(WithLogger(logrus.New()), WithPlugins(plugins.Commands))
+			Expect(measured.RunStages(context.Background(), []string{"test", "other"}, fs, testConsole, "/some/deploy")).ToNot(Succeed())
+			finished := measured.Report().Stages[0].Finished
+			Expect(finished).ToNot(BeZero())
+
+			out := &bytes.Buffer{}
+			Expect(measured.Report().WritePrometheus(out)).To(Succeed())
+			metrics := out.String()
+			Expect(metrics).To(ContainSubstring("# TYPE depcfg_stage_last_run_timestamp_seconds gauge\n"))
+			Expect(metrics).To(ContainSubstring(fmt.Sprintf("depcfg_stage_last_run_timestamp_seconds{stage=\"test\"} %s\n",
+				strconv.FormatFloat(float64(finished.UnixNano())/1e9, 'f', -1, 64))))
+			Expect(metrics).To(ContainSubstring(`depcfg_steps{stage="test",result="applied"} 1` + "\n"))
+			Expect(metrics).To(ContainSubstring(`depcfg_steps{stage="test",result="skipped"} 1` + "\n"))
+			Expect(metrics).To(ContainSubstring(`depcfg_steps{stage="test",result="failed"} 1` + "\n"))
+			Expect(metrics).To(ContainSubstring(`depcfg_steps{stage="other",result="applied"} 1` + "\n"))
+			Expect(metrics).To(MatchRegexp(`depcfg_plugin_duration_seconds\{stage="test",plugin="Commands"\} [0-9.e-]+\n`))
+			Expect(metrics).To(ContainSubstring(`depcfg_stage_failed{stage="other"} 0` + "\n"))
+			Expect(metrics).To(ContainSubstring(`depcfg_stage_failed{stage="test"} 1` + "\n"))
+			Expect(metrics).To(HaveSuffix("depcfg_run_failed 1\n"))
+		})
+
+		It("Reports the steps sharing a name apart", func() {
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {
+					{Name: "same", Commands: []string{"exit 1"}},
+					{Name: "same", Commands: []string{"true"}},
+				},
+			}}
+			parallel := NewExecutor(WithLogger(logrus.New()), WithParallel(2), WithPlugins(plugins.Commands))
+			Expect(parallel.Apply("foo", config, vfs.OSFS, console.NewStandardConsole())).ToNot(Succeed())
+
+			steps := parallel.Report().Stages[0].Sources[0].Steps
+			Expect(steps).To(HaveLen(2))
+			var statuses []string
+			for _, st := range steps {
+				Expect(st.Name).To(Equal("same"))
+				Expect(st.Plugins).To(HaveLen(1))
+				statuses = append(statuses, st.Status)
+			}
+			Expect(statuses).To(ConsistOf(StatusFailure, StatusSuccess))
+		})
+
+		It("Runs several stages with their companions, loading the sources once", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/deploy/01_first.yaml": `
+stages:
+  boot.after:
+  - commands:
+    - echo boot.after
+  initramfs:
+  - commands:
+    - echo initramfs
+`,
+				"/some/deploy/02_second.yaml": `
+stages:
+  boot:
+  - commands:
+    - echo boot
+  boot.before:
+  - commands:
+    - echo boot.before
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			companions := NewExecutor(WithLogger(logrus.New()), WithCompanionStages(true))
+			consoletests.Reset()
+			err = companions.RunStages(context.Background(), []string{"initramfs", "boot"}, fs, testConsole, "/some/deploy")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(consoletests.Commands).To(Equal([]string{"echo initramfs", "echo boot.before", "echo boot", "echo boot.after"}))
+
+			var stages []string
+			for _, st := range companions.Report().Stages {
+				stages = append(stages, st.Name)
+			}
+			Expect(stages).To(Equal([]string{
+				"initramfs.before", "initramfs", "initramfs.after", "boot.before", "boot", "boot.after",
+			}))
+		})
+
+		It("Splits comma separated stages", func() {
+			Expect(SplitStages("initramfs,boot")).To(Equal([]string{"initramfs", "boot"}))
+			Expect(SplitStages(" initramfs , boot.before,,boot ,")).To(Equal([]string{"initramfs", "boot.before", "boot"}))
+			Expect(SplitStages(" , ")).To(BeEmpty())
+		})
+
+		It("Sorts, overrides, extends and masks the configs of several directories", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/system/oem/10_a.yaml":           "stages:\n  foo:\n  - commands:\n    - echo vendor a\n",
+				"/system/oem/10_a.yaml.d/01.yaml": "stages:\n  foo:\n  - commands:\n    - echo vendor drop-in\n",
+				"/system/oem/10_a.yaml.d/02.yaml": "stages:\n  foo:\n  - commands:\n    - echo masked drop-in\n",
+				"/system/oem/20_b.yaml":           "stages:\n  foo:\n  - commands:\n    - echo vendor b\n",
+				"/system/oem/30_c.yaml":           "stages:\n  foo:\n  - commands:\n    - echo vendor c\n",
+				"/oem/05_x.yaml":                  "stages:\n  foo:\n  - commands:\n    - echo x\n",
+				"/oem/10_a.yaml.d/02.yaml":        "",
+				"/oem/10_a.yaml.d/03.yaml":        "stages:\n  foo:\n  - commands:\n    - echo admin drop-in\n",
+				"/oem/20_b.yaml":                  "stages:\n  foo:\n  - commands:\n    - echo admin b\n",
+				"/oem/30_c.yaml":                  &vfst.Symlink{Target: "/dev/null"},
+				"/oem/40_d.yaml.d/01.yaml":        "stages:\n  foo:\n  - commands:\n    - echo orphan drop-in\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			consoletests.Reset()
+			Expect(def.Run("foo", fs, testConsole, "/system/oem", "/oem")).To(Succeed())
+			Expect(consoletests.Commands).To(Equal([]string{
+				"echo x", "echo vendor a", "echo vendor drop-in", "echo admin drop-in", "echo admin b",
+			}))
+		})
+
+		It("Sorts the configs of subdirectories by basename", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/system/oem/a/30_c.yaml":   "stages:\n  foo:\n  - commands:\n    - echo vendor c\n",
+				"/system/oem/z/10_a.yaml":   "stages:\n  foo:\n  - commands:\n    - echo vendor a\n",
+				"/system/oem/20_b.yaml":     "stages:\n  foo:\n  - commands:\n    - echo vendor b\n",
+				"/oem/b/10_a.yaml":          "stages:\n  foo:\n  - commands:\n    - echo admin a\n",
+				"/oem/z/10_a.yaml":          "stages:\n  foo:\n  - commands:\n    - echo admin override\n",
+				"/oem/z/30_c.yaml.d/x.yaml": "stages:\n  foo:\n  - commands:\n    - echo orphan\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			consoletests.Reset()
+			Expect(def.Run("foo", fs, testConsole, "/system/oem", "/oem")).To(Succeed())
+			Expect(consoletests.Commands).To(Equal([]string{
+				"echo admin a", "echo admin override", "echo vendor b", "echo vendor c",
+			}))
+		})
+
+		It("Applies only the steps of the selected sources", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/system/oem/10_base.yaml": "stages:\n  foo:\n  - id: base\n    commands: [echo base]\n",
+				"/system/oem/20_app.yaml":  "stages:\n  foo:\n  - commands: [echo system app]\n",
+				"/oem/20_app.yaml":         "stages:\n  foo:\n  - depends_on: [base]\n    commands: [echo app]\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			selected := NewExecutor(WithLogger(logrus.New()), WithSources("/oem/20_app.yaml", "/system/oem/20_app.yaml"))
+			consoletests.Reset()
+			Expect(selected.Run("foo", fs, testConsole, "/system/oem", "/oem")).To(Succeed())
+			Expect(consoletests.Commands).To(Equal([]string{"echo app"}))
+		})
+
+		It("Loads the configs from the source fs", func() {
+			sourceFS, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/oem/01_files.yaml": `
+stages:
+  foo:
+  - files:
+    - path: /etc/foo.conf
+      content: foo
+      permissions: 0644
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+			fs, cleanup2, err := vfst.NewTestFS(map[string]interface{}{"/etc/bar.conf": "bar"})
+			Expect(err).Should(BeNil())
+			defer cleanup2()
+
+			offline := NewExecutor(WithLogger(logrus.New()), WithSourceFS(sourceFS))
+			Expect(offline.Run("foo", fs, testConsole, "/oem")).To(Succeed())
+			content, err := fs.ReadFile("/etc/foo.conf")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal("foo"))
+			_, err = sourceFS.Stat("/etc/foo.conf")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("Loads the configs both included and in a directory once", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/deploy/01_first.yaml":  "include:\n- 03_third.yaml\nstages:\n  foo:\n  - commands: [echo first]\n",
+				"/some/deploy/02_second.yaml": "include:\n- 01_first.yaml\nstages:\n  foo:\n  - commands: [echo second]\n",
+				"/some/deploy/03_third.yaml":  "stages:\n  foo:\n  - id: third\n    commands: [echo third]\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			consoletests.Reset()
+			Expect(def.Run("foo", fs, testConsole, "/some/deploy")).To(Succeed())
+			Expect(consoletests.Commands).To(Equal([]string{"echo third", "echo first", "echo second"}))
+		})
+
+		It("Renders the variables in every field of the steps", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/deploy/01_first.yaml": `
+vars:
+  dir: /etc/app
+  name: config
+  level: info
+stages:
+  foo:
+  - vars:
+      name: step
+    files:
+    - path: "{{.Vars.dir}}/{{.Vars.name}}.conf"
+      content: "level={{.Vars.level}}"
+      permissions: 0644
+    commands:
+    - echo {{.Vars.name}} {{.Vars.level}} {{.Values.os.architecture}}
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			templated := NewExecutor(WithLogger(logrus.New()), WithVars(map[string]string{"level": "debug"}))
+			consoletests.Reset()
+			Expect(templated.Run("foo", fs, testConsole, "/some/deploy")).To(Succeed())
+			Expect(consoletests.Commands).To(Equal([]string{"echo step debug " + runtime.GOARCH}))
+			content, err := fs.ReadFile("/etc/app/step.conf")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal("level=debug"))
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {{Name: "missing", Commands: []string{"echo {{.Vars.nope}}"}}},
+			}}
+			consoletests.Reset()
+			err = templated.Apply("foo", config, fs, testConsole)
+			Expect(err).To(MatchError(ContainSubstring("rendering step 'missing': commands[0]")))
+			Expect(consoletests.Commands).To(BeEmpty())
+			Expect(config.Stages["foo"][0].Commands[0]).To(Equal("echo {{.Vars.nope}}"))
+		})
+
+		It("Renders only the steps about to run, leaving the templates it can't render", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/deploy/01_first.yaml": `
+stages:
+  foo:
+  - name: skipped
+    only_if: "false"
+    commands:
+    - echo {{.Vars.nope}}
+  - name: names
+    commands:
+    - docker ps --format '{{.Names}}'
+    - echo {{.UUID}}
+    files:
+    - path: /tmp/blob
+      encoding: b64
+      content: e3suVmFycy5ub3BlfX0=
+      permissions: 0644
+  - name: uuid
+    commands:
+    - echo {{.UUID}}
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			templated := NewExecutor(WithLogger(logrus.New()))
+			consoletests.Reset()
+			Expect(templated.Run("foo", fs, testConsole, "/some/deploy")).To(Succeed())
+			Expect(consoletests.Commands).To(HaveLen(3))
+			Expect(consoletests.Commands[0]).To(Equal("docker ps --format '{{.Names}}'"))
+			Expect(consoletests.Commands[1]).ToNot(ContainSubstring("{{"))
+			Expect(consoletests.Commands[2]).To(Equal(consoletests.Commands[1]))
+			content, err := fs.ReadFile("/tmp/blob")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal("{{.Vars.nope}}"))
+		})
+
+		It("Masks the secrets of the steps in the logs and the events", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/deploy/01_first.yaml": `
+stages:
+  foo:
+  - name: login
+    once: true
+    environment:
+      USER: admin
+      TOKEN:
+        value: s3cr3t-token
+        secret: true
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			out := &bytes.Buffer{}
+			l := logrus.New()
+			l.SetOutput(out)
+			l.SetLevel(logrus.DebugLevel)
+			var events []Event
+			failing := func(ctx context.Context, l logger.Interface, s schema.Stage, fs vfs.FS, console plugins.Console) error {
+				return errors.New("login rejected with " + s.Environment["TOKEN"])
+			}
+			j, err := journal.Load("/var/lib/depcfg/journal.json", fs)
+			Expect(err).ShouldNot(HaveOccurred())
+			redacted := NewExecutor(
+				WithLogger(l),
+				WithContextPlugins(failing),
+				WithEventHandler(func(e Event) { events = append(events, e) }),
+				WithJournal(j),
+			)
+			Expect(redacted.Run("foo", fs, testConsole, "/some/deploy")).ToNot(Succeed())
+
+			Expect(out.String()).To(ContainSubstring("admin"))
+			Expect(out.String()).To(ContainSubstring("login rejected with ******"))
+			Expect(out.String()).ToNot(ContainSubstring("s3cr3t-token"))
+			for _, e := range events {
+				Expect(e.Error).ToNot(ContainSubstring("s3cr3t-token"))
+			}
+			Expect(redacted.Report().Stages[0].Sources[0].Steps[0].Error).To(ContainSubstring("login rejected with ******"))
+			saved, err := fs.ReadFile("/var/lib/depcfg/journal.json")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(string(saved)).To(ContainSubstring("login rejected with ******"))
+			Expect(string(saved)).ToNot(ContainSubstring("s3cr3t-token"))
+		})
+
+		It("Selects the plugins to run from the registry", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/tmp": &vfst.Dir{Perm: 0755}})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {{
+					Commands: []string{"echo foo"},
+					Files:    []schema.File{{Path: "/tmp/foo", Content: "foo", Permissions: 0644}},
+				}},
+			}}
+
+			disabled := NewExecutor(WithLogger(logrus.New()), WithDisabledPlugins("commands", "git"))
+			consoletests.Reset()
+			Expect(disabled.Apply("foo", config, fs, testConsole)).To(Succeed())
+			Expect(consoletests.Commands).To(BeEmpty())
+			_, err = fs.Stat("/tmp/foo")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fs.Remove("/tmp/foo")).To(Succeed())
+
+			enabled := NewExecutor(WithLogger(logrus.New()), WithEnabledPlugins("commands"))
+			consoletests.Reset()
+			Expect(enabled.Apply("foo", config, fs, testConsole)).To(Succeed())
+			Expect(consoletests.Commands).To(Equal([]string{"echo foo"}))
+			_, err = fs.Stat("/tmp/foo")
+			Expect(err).To(HaveOccurred())
+
+			r := NewRegistry()
+			Expect(r.Register("custom", AdaptPlugin(plugins.Commands), nil)).To(Succeed())
+			Expect(r.Register("custom", AdaptPlugin(plugins.Commands), nil)).ToNot(Succeed())
+			Expect(r.Names()).To(Equal([]string{"custom"}))
+			Expect(r.Validate("custom", "files")).To(MatchError(ContainSubstring("unknown plugin 'files'")))
+			Expect(DefaultRegistry().Validate("files", "layout", "datasource")).To(Succeed())
+		})
+
+		It("Restricts the steps of a config to the plugins it allows", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/some/deploy/01_first.yaml": `
+plugins:
+- files
+stages:
+  foo:
+  - commands:
+    - echo restricted
+`,
+				"/some/deploy/02_second.yaml": `
+stages:
+  foo:
+  - commands:
+    - echo unrestricted
+`,
+				"/other/deploy/01_unknown.yaml": `
+plugins:
+- nope
+stages:
+  foo:
+  - commands:
+    - echo unknown
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			consoletests.Reset()
+			Expect(def.Run("foo", fs, testConsole, "/some/deploy")).To(Succeed())
+			Expect(consoletests.Commands).To(Equal([]string{"echo unrestricted"}))
+
+			consoletests.Reset()
+			err = def.Run("foo", fs, testConsole, "/other/deploy")
+			Expect(err).To(MatchError(ContainSubstring("allows unknown plugin 'nope'")))
+			Expect(consoletests.Commands).To(BeEmpty())
+
+			// The plugins set explicitly are named after their functions, and run in the order given
+			explicit := NewExecutor(WithLogger(logrus.New()), WithPlugins(plugins.EnsureFiles, plugins.Commands))
+			consoletests.Reset()
+			err = explicit.Run("foo", fs, testConsole, "/some/deploy")
+			Expect(err).To(MatchError(ContainSubstring("allows unknown plugin 'files'")))
+			Expect(consoletests.Commands).To(BeEmpty())
+
+			config := schema.BhojpurConfig{Plugins: []string{"Commands"}, Stages: map[string][]schema.Stage{
+				"foo": {{Commands: []string{"echo allowed"}}},
+			}}
+			consoletests.Reset()
+			Expect(explicit.Apply("foo", config, fs, testConsole)).To(Succeed())
+			Expect(consoletests.Commands).To(Equal([]string{"echo allowed"}))
+
+			config.Plugins = nil
+			ordered := NewExecutor(WithLogger(logrus.New()), WithPlugins(plugins.EnsureFiles, plugins.Commands))
+			Expect(ordered.Apply("foo", config, fs, testConsole)).To(Succeed())
+			var names []string
+			for _, p := range ordered.Report().Stages[0].Sources[0].Steps[0].Plugins {
+				names = append(names, p.Name)
+			}
+			Expect(names).To(Equal([]string{"EnsureFiles", "Commands"}))
+
+			disabled := NewExecutor(
+				WithLogger(logrus.New()),
+				WithPlugins(plugins.Commands),
+				WithDisabledPlugins("Commands"),
+			)
+			consoletests.Reset()
+			Expect(disabled.Apply("foo", config, fs, testConsole)).To(Succeed())
+			Expect(consoletests.Commands).To(BeEmpty())
+		})
+
+		It("Skips steps with only_if and unless expressions", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/etc/hosts": "127.0.0.1 boo"})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			config := schema.BhojpurConfig{Stages: map[string][]schema.Stage{
+				"foo": {
+					{ID: "hosts", OnlyIf: `exists "/etc/hosts"`, Commands: []string{"echo hosts"}},
+					{ID: "missing", OnlyIf: `exists "/etc/missing"`, Commands: []string{"echo missing"}},
+					{ID: "after", DependsOn: []string{"hosts"}, OnlyIf: `eq (step "hosts") "success"`, Commands: []string{"echo after"}},
+					{ID: "unless", DependsOn: []string{"missing"}, Unless: `eq (step "missing") "skipped"`, Commands: []string{"echo unless"}},
+				},
+			}}
+			conditional := NewExecutor(WithLogger(logrus.New()), WithPlugins(plugins.Commands))
+			consoletests.Reset()
+			Expect(conditional.Apply("foo", config, fs, testConsole)).To(Succeed())
+			Expect(consoletests.Commands).To(Equal([]string{"echo hosts", "echo after"}))
+		})
+
 		It("Skip with if conditionals", func() {
 			testConsole := console.NewStandardConsole()
 
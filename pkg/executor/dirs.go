@@ -0,0 +1,188 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bhojpur/deploy/pkg/schema"
+	"github.com/twpayne/go-vfs"
+)
+
+// configFiles are the config files of a set of source directories, by their path relative
+// to the directory they are in. A file overrides the ones with the same name in the
+// directories scanned before, and the masked files are recorded with an empty path.
+type configFiles struct {
+	files   map[string]string
+	dropins map[string]map[string]string
+}
+
+func newConfigFiles() *configFiles {
+	return &configFiles{files: map[string]string{}, dropins: map[string]map[string]string{}}
+}
+
+func isConfigFile(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// isMask tells if a file masks the ones with the same name, being empty or a link to /dev/null
+func isMask(path string, info os.FileInfo, fs vfs.FS) bool {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := fs.Readlink(path)
+		return err == nil && target == os.DevNull
+	}
+	return info.Size() == 0
+}
+
+// scan adds the config files of dir. The <name>.d directories next to a config file
+// hold its drop-ins.
+func (c *configFiles) scan(dir string, fs vfs.FS) error {
+	return vfs.Walk(fs, dir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if path == dir {
+				return nil
+			}
+			name, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+
+			if info.IsDir() {
+				if extended := strings.TrimSuffix(name, ".d"); extended != name && isConfigFile(extended) {
+					if err := c.scanDropins(extended, path, fs); err != nil {
+						return err
+					}
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !isConfigFile(path) {
+				return nil
+			}
+
+			c.files[name] = path
+			if isMask(path, info, fs) {
+				c.files[name] = ""
+			}
+			return nil
+		})
+}
+
+func (c *configFiles) scanDropins(name, dir string, fs vfs.FS) error {
+	infos, err := fs.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if c.dropins[name] == nil {
+		c.dropins[name] = map[string]string{}
+	}
+	for _, info := range infos {
+		path := filepath.Join(dir, info.Name())
+		if info.IsDir() || !isConfigFile(path) {
+			continue
+		}
+		c.dropins[name][info.Name()] = path
+		if isMask(path, info, fs) {
+			c.dropins[name][info.Name()] = ""
+		}
+	}
+	return nil
+}
+
+// sortedFiles returns the names of the config files sorted by their basename across the
+// subdirectories, and then by their path
+func (c *configFiles) sortedFiles() []string {
+	names := sortedKeys(c.files)
+	sort.SliceStable(names, func(i, j int) bool {
+		bi, bj := filepath.Base(names[i]), filepath.Base(names[j])
+		if bi != bj {
+			return bi < bj
+		}
+		return c.files[names[i]] < c.files[names[j]]
+	})
+	return names
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// loadDirs loads the config files of the directories, sorted by basename across all of them.
+// Each config file is followed by its drop-ins, which share its name and plugins unless
+// they set their own. Drop-ins of masked or missing files are ignored.
+func (e *DefaultExecutor) loadDirs(dirs []string, fs vfs.FS) (sources []source, failedURIs []string, errs []error) {
+	c := newConfigFiles()
+	for _, dir := range dirs {
+		if err := c.scan(dir, fs); err != nil {
+			failedURIs = append(failedURIs, dir)
+			errs = append(errs, err)
+		}
+	}
+
+	for _, name := range c.sortedFiles() {
+		path := c.files[name]
+		if path == "" {
+			e.logger.Debugf("Config %s is masked\n", name)
+			continue
+		}
+		src, err := e.load(path, fs, schema.FromFile)
+		if err != nil {
+			failedURIs = append(failedURIs, path)
+			errs = append(errs, err)
+			continue
+		}
+		sources = append(sources, src)
+
+		for _, dropin := range sortedKeys(c.dropins[name]) {
+			path := c.dropins[name][dropin]
+			if path == "" {
+				e.logger.Debugf("Drop-in %s of %s is masked\n", dropin, name)
+				continue
+			}
+			d, err := e.load(path, fs, schema.FromFile)
+			if err != nil {
+				failedURIs = append(failedURIs, path)
+				errs = append(errs, err)
+				continue
+			}
+			if d.config.Name == "" {
+				d.config.Name = src.config.Name
+			}
+			if len(d.config.Plugins) == 0 {
+				d.config.Plugins = src.config.Plugins
+			}
+			sources = append(sources, d)
+		}
+	}
+	return sources, failedURIs, errs
+}
@@ -0,0 +1,119 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Types of the events emitted by the executor
+const (
+	EventStageStarted   = "stage_started"
+	EventStageFinished  = "stage_finished"
+	EventStepStarted    = "step_started"
+	EventStepFinished   = "step_finished"
+	EventStepSkipped    = "step_skipped"
+	EventPluginStarted  = "plugin_started"
+	EventPluginFinished = "plugin_finished"
+	EventError          = "error"
+)
+
+// Event describes something the executor did. Fields not relevant to the
+// event type are left empty.
+type Event struct {
+	Type     string        `json:"type"`
+	Time     time.Time     `json:"time"`
+	Stage    string        `json:"stage,omitempty"`
+	Step     string        `json:"step,omitempty"`
+	Index    int           `json:"index,omitempty"`
+	Source   string        `json:"source,omitempty"`
+	Plugin   string        `json:"plugin,omitempty"`
+	Attempt  int           `json:"attempt,omitempty"`
+	Reason   string        `json:"reason,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// EventHandler receives the events emitted by the executor. Calls are
+// serialized, even when steps run in parallel, so handlers should return
+// quickly.
+type EventHandler func(Event)
+
+// JSONLinesHandler returns an EventHandler writing each event to w as a line
+// of JSON. Durations are written in nanoseconds.
+func JSONLinesHandler(w io.Writer) EventHandler {
+	enc := json.NewEncoder(w)
+	return func(ev Event) {
+		enc.Encode(ev)
+	}
+}
+
+// events dispatches the events of an executor to its handlers
+type events struct {
+	mu       sync.Mutex
+	handlers []EventHandler
+	redact   func(string) string
+}
+
+func (ev *events) emit(e Event) {
+	if ev == nil || len(ev.handlers) == 0 {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if ev.redact != nil {
+		e.Step = ev.redact(e.Step)
+		e.Source = ev.redact(e.Source)
+		e.Reason = ev.redact(e.Reason)
+		e.Error = ev.redact(e.Error)
+	}
+
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	for _, h := range ev.handlers {
+		h(e)
+	}
+}
+
+// stepEvent returns an event of type t about the step s. The steps are numbered from 1
+// in their source, as their names can be shared.
+func stepEvent(t, stageName string, s step) Event {
+	return Event{Type: t, Stage: stageName, Step: s.label(), Index: s.index + 1, Source: s.uri}
+}
+
+// pluginEvent returns an event of type t about a plugin run for the step s
+func pluginEvent(t, stageName string, s step, plugin string, attempt int) Event {
+	e := stepEvent(t, stageName, s)
+	e.Plugin = plugin
+	e.Attempt = attempt
+	return e
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -21,8 +21,16 @@ package executor
 // THE SOFTWARE.
 
 import (
+	"context"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/bhojpur/deploy/pkg/journal"
 	"github.com/bhojpur/deploy/pkg/logger"
 	"github.com/bhojpur/deploy/pkg/plugins"
+	"github.com/bhojpur/deploy/pkg/utils"
 	"github.com/sirupsen/logrus"
 	"github.com/twpayne/go-vfs"
 
@@ -32,14 +40,95 @@ import (
 // Executor an executor applies a Bhojpur Deploy config
 type Executor interface {
 	Apply(string, schema.BhojpurConfig, vfs.FS, plugins.Console) error
+	ApplyContext(context.Context, string, schema.BhojpurConfig, vfs.FS, plugins.Console) error
 	Run(string, vfs.FS, plugins.Console, ...string) error
+	RunContext(context.Context, string, vfs.FS, plugins.Console, ...string) error
+	RunStages(context.Context, []string, vfs.FS, plugins.Console, ...string) error
 	Plugins([]Plugin)
 	Conditionals([]Plugin)
 	Modifier(m schema.Modifier)
+	Plan() *Plan
+	Report() *RunReport
 }
 
 type Plugin func(logger.Interface, schema.Stage, vfs.FS, plugins.Console) error
 
+// ContextPlugin is a Plugin which gets the context of the step it runs for,
+// and is expected to return once the context is done
+type ContextPlugin func(context.Context, logger.Interface, schema.Stage, vfs.FS, plugins.Console) error
+
+// AdaptPlugin turns a Plugin into a ContextPlugin. The plugin is not run if the
+// context is already done, and the console it gets from the executor runs the
+// commands bound to the context.
+func AdaptPlugin(p Plugin) ContextPlugin {
+	return func(ctx context.Context, l logger.Interface, s schema.Stage, fs vfs.FS, console plugins.Console) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return p(l, s, fs, console)
+	}
+}
+
+// namedPlugin is a plugin along with the name it is reported as
+type namedPlugin struct {
+	name   string
+	plugin ContextPlugin
+}
+
+// namedPlanner is a planner along with the name of the plugin it plans for
+type namedPlanner struct {
+	name    string
+	planner Planner
+}
+
+func adaptPlugins(p []Plugin) []ContextPlugin {
+	adapted := make([]ContextPlugin, len(p))
+	for i := range p {
+		adapted[i] = AdaptPlugin(p[i])
+	}
+	return adapted
+}
+
+// pluginRegistry returns a Registry with the plugins p, without planners, named after
+// their functions and running in the order given
+func pluginRegistry(p []Plugin) (*Registry, error) {
+	adapted := make([]ContextPlugin, len(p))
+	names := make([]string, len(p))
+	for i := range p {
+		adapted[i] = AdaptPlugin(p[i])
+		names[i] = funcName(p[i])
+	}
+	return namedRegistry(names, adapted)
+}
+
+// contextPluginRegistry is pluginRegistry for ContextPlugins
+func contextPluginRegistry(p []ContextPlugin) (*Registry, error) {
+	names := make([]string, len(p))
+	for i := range p {
+		names[i] = funcName(p[i])
+	}
+	return namedRegistry(names, p)
+}
+
+func namedRegistry(names []string, p []ContextPlugin) (*Registry, error) {
+	r := NewRegistry()
+	for i := range p {
+		if err := r.Register(names[i], p[i], nil); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// funcName returns the name of the function f, without its package
+func funcName(f interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// Planner describes the changes a Plugin would apply, without applying them
+type Planner func(logger.Interface, schema.Stage, vfs.FS, plugins.Console) ([]plugins.Change, error)
+
 type Options func(d *DefaultExecutor) error
 
 // WithLogger sets the logger for the cloudrunner
@@ -50,10 +139,28 @@ func WithLogger(i logger.Interface) Options {
 	}
 }
 
-// WithPlugins sets the plugins for the cloudrunner
+// WithPlugins sets the plugins for the cloudrunner, running in the order given. They are
+// named after their functions, for the configs allowing them and for enabling or disabling
+// them, and have no planners: use WithRegistry to name them otherwise and to plan their changes.
 func WithPlugins(p ...Plugin) Options {
 	return func(d *DefaultExecutor) error {
-		d.plugins = p
+		r, err := pluginRegistry(p)
+		if err != nil {
+			return err
+		}
+		d.registry = r
+		return nil
+	}
+}
+
+// WithContextPlugins sets the plugins for the cloudrunner, like WithPlugins
+func WithContextPlugins(p ...ContextPlugin) Options {
+	return func(d *DefaultExecutor) error {
+		r, err := contextPluginRegistry(p)
+		if err != nil {
+			return err
+		}
+		d.registry = r
 		return nil
 	}
 }
@@ -61,7 +168,132 @@ func WithPlugins(p ...Plugin) Options {
 // WithConditionals sets the conditionals for the cloudrunner
 func WithConditionals(p ...Plugin) Options {
 	return func(d *DefaultExecutor) error {
-		d.conditionals = p
+		d.conditionals = adaptPlugins(p)
+		return nil
+	}
+}
+
+// WithRegistry sets the registry the cloudrunner takes its plugins from
+func WithRegistry(r *Registry) Options {
+	return func(d *DefaultExecutor) error {
+		d.registry = r
+		return nil
+	}
+}
+
+// WithEnabledPlugins makes the cloudrunner run only the given plugins of its registry
+func WithEnabledPlugins(names ...string) Options {
+	return func(d *DefaultExecutor) error {
+		d.enabled = names
+		return nil
+	}
+}
+
+// WithDisabledPlugins makes the cloudrunner run none of the given plugins of its registry
+func WithDisabledPlugins(names ...string) Options {
+	return func(d *DefaultExecutor) error {
+		d.disabled = names
+		return nil
+	}
+}
+
+// WithDryRun makes the cloudrunner only collect the changes the plugins would
+// apply, instead of applying them
+func WithDryRun(b bool) Options {
+	return func(d *DefaultExecutor) error {
+		d.dryRun = b
+		return nil
+	}
+}
+
+// WithParallel makes the cloudrunner run up to n independent steps of a stage
+// concurrently. Steps still wait for the steps listed in their depends_on.
+func WithParallel(n int) Options {
+	return func(d *DefaultExecutor) error {
+		d.parallel = n
+		return nil
+	}
+}
+
+// WithFailFast makes the cloudrunner abort the run at the first failing step,
+// unless the step sets a different on_failure policy
+func WithFailFast(b bool) Options {
+	return func(d *DefaultExecutor) error {
+		d.failFast = b
+		return nil
+	}
+}
+
+// WithJournal makes the cloudrunner record the steps it applies in j, and
+// skip the steps marked as once or run on_change accordingly
+func WithJournal(j *journal.Journal) Options {
+	return func(d *DefaultExecutor) error {
+		d.journal = j
+		return nil
+	}
+}
+
+// WithEventHandler subscribes h to the events emitted by the cloudrunner.
+// It can be given multiple times to add more handlers.
+func WithEventHandler(h EventHandler) Options {
+	return func(d *DefaultExecutor) error {
+		d.events.handlers = append(d.events.handlers, h)
+		return nil
+	}
+}
+
+// WithCompanionStages makes the cloudrunner run the <stage>.before and
+// <stage>.after stages around each stage it is asked to run
+func WithCompanionStages(b bool) Options {
+	return func(d *DefaultExecutor) error {
+		d.companions = b
+		return nil
+	}
+}
+
+// WithVars sets variables for the templates in the steps, overriding the ones
+// the configs and the steps define
+func WithVars(vars map[string]string) Options {
+	return func(d *DefaultExecutor) error {
+		d.vars = vars
+		return nil
+	}
+}
+
+// WithLock makes the cloudrunner hold an advisory lock on path while it runs,
+// waiting up to timeout for other runs to release it. An empty path disables it.
+func WithLock(path string, timeout time.Duration) Options {
+	return func(d *DefaultExecutor) error {
+		d.lockPath = path
+		d.lockTimeout = timeout
+		return nil
+	}
+}
+
+// WithRedactor sets the redactor masking the secrets of the steps in the logs,
+// the events, the reports and the plans. The secrets of the steps run are added to it.
+func WithRedactor(r *utils.Redactor) Options {
+	return func(d *DefaultExecutor) error {
+		d.redactor = r
+		return nil
+	}
+}
+
+// WithSources makes the cloudrunner apply only the steps of the configs loaded from the
+// given files. All the configs are still loaded, so directories keep overriding and masking
+// their files, and the steps the selected ones depend on are taken as already applied.
+func WithSources(uris ...string) Options {
+	return func(d *DefaultExecutor) error {
+		d.sources = append([]string{}, uris...)
+		return nil
+	}
+}
+
+// WithSourceFS makes the cloudrunner load the configs from fs, instead of the fs
+// their steps apply to. It lets the configs of the host apply to an offline root.
+func WithSourceFS(fs vfs.FS) Options {
+	return func(d *DefaultExecutor) error {
+		d.sourceFS = fs
 		return nil
 	}
 }
@@ -69,36 +301,29 @@ func WithConditionals(p ...Plugin) Options {
 // NewExecutor returns an executor from the stringified version of it.
 func NewExecutor(opts ...Options) Executor {
 	d := &DefaultExecutor{
-		logger: logrus.New(),
-		conditionals: []Plugin{
-			plugins.NodeConditional,
-			plugins.IfConditional,
-		},
-		plugins: []Plugin{
-			plugins.DNS,
-			plugins.Download,
-			plugins.Git,
-			plugins.Entities,
-			plugins.EnsureDirectories,
-			plugins.EnsureFiles,
-			plugins.Commands,
-			plugins.DeleteEntities,
-			plugins.Hostname,
-			plugins.Sysctl,
-			plugins.User,
-			plugins.SSH,
-			plugins.LoadModules,
-			plugins.Timesyncd,
-			plugins.Systemctl,
-			plugins.Environment,
-			plugins.SystemdFirstboot,
-			plugins.DataSources,
-			plugins.Layout,
-		},
+		logger:   logrus.New(),
+		registry: DefaultRegistry(),
+		redactor: utils.NewRedactor(),
+		plan:     &Plan{},
+		report:   &RunReport{},
+	}
+	d.events = &events{handlers: []EventHandler{d.report.handle}}
+	d.conditionals = []ContextPlugin{
+		AdaptPlugin(plugins.NodeConditional),
+		AdaptPlugin(plugins.MatchConditional),
+		AdaptPlugin(plugins.IfConditional),
+		AdaptPlugin(plugins.ExpressionConditional(d.report.StepStatus)),
 	}
 
 	for _, o := range opts {
-		o(d)
+		if err := o(d); err != nil {
+			d.logger.Warnf("Invalid executor option: %s", err.Error())
+		}
 	}
+
+	d.selectPlugins()
+
+	d.logger = logger.WithRedaction(d.logger, d.redactor.Redact)
+	d.events.redact = d.redactor.Redact
 	return d
 }
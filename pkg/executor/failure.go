@@ -0,0 +1,88 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+
+	"github.com/bhojpur/deploy/pkg/schema"
+	"github.com/hashicorp/go-multierror"
+)
+
+// StepError is returned when a failing step stops the stage it belongs to,
+// according to its on_failure policy
+type StepError struct {
+	Stage  string
+	Step   string
+	Source string
+	Policy string
+	Err    error
+}
+
+func (e *StepError) Error() string {
+	msg := fmt.Sprintf("stage '%s' stopped by step '%s'", e.Stage, e.Step)
+	if e.Source != "" {
+		msg += fmt.Sprintf(" (%s)", e.Source)
+	}
+	return fmt.Sprintf("%s: %s", msg, e.Err.Error())
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// Aborted tells if the step stopped the whole run, rather than only its stage
+func (e *StepError) Aborted() bool {
+	return e.Policy == schema.OnFailureAbort
+}
+
+// failurePolicy returns what to do when the step fails
+func (e *DefaultExecutor) failurePolicy(s step) string {
+	switch {
+	case s.OnFailure != "":
+		return s.OnFailure
+	case e.failFast:
+		return schema.OnFailureAbort
+	default:
+		return schema.OnFailureContinue
+	}
+}
+
+// checkPolicies makes sure all the steps have known on_failure and run policies
+func checkPolicies(steps []step) error {
+	var errs error
+	for _, s := range steps {
+		switch s.OnFailure {
+		case "", schema.OnFailureContinue, schema.OnFailureAbort, schema.OnFailureSkipRemaining:
+		default:
+			errs = multierror.Append(errs, fmt.Errorf("step %s has an invalid on_failure policy '%s'", s, s.OnFailure))
+		}
+		switch s.Run {
+		case "", schema.RunAlways, schema.RunOnChange:
+		default:
+			errs = multierror.Append(errs, fmt.Errorf("step %s has an invalid run policy '%s'", s, s.Run))
+		}
+		if s.Retries < 0 || s.RetryDelay < 0 || s.RetryBackoff < 0 {
+			errs = multierror.Append(errs, fmt.Errorf("step %s has negative retries, retry_delay or retry_backoff", s))
+		}
+	}
+	return errs
+}
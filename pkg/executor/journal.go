@@ -0,0 +1,101 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bhojpur/deploy/pkg/journal"
+	"github.com/bhojpur/deploy/pkg/logger"
+	"github.com/bhojpur/deploy/pkg/schema"
+)
+
+// journalKey identifies the step in the journal. Steps with an id keep their
+// entry when moved to another file, the other ones are identified by their name,
+// or else by their position in the file.
+func (s step) journalKey(stageName string) string {
+	if s.ID != "" {
+		return stageName + "/" + s.ID
+	}
+	src := s.uri
+	if src == "" {
+		src = s.config
+	}
+	return fmt.Sprintf("%s/%s/%s", stageName, src, s.label())
+}
+
+// hash returns a digest of the step definition
+func (s step) hash() string {
+	b, _ := json.Marshal(s.Stage)
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// upToDate returns why the step doesn't need to run according to the
+// journal, or an empty string if it has to
+func (e *DefaultExecutor) upToDate(stageName string, s step) string {
+	if !s.Once && s.Run != schema.RunOnChange {
+		return ""
+	}
+	if e.journal == nil {
+		e.logger.Warnf("No journal to check step %s against, running it\n", s)
+		return ""
+	}
+
+	entry, ok := e.journal.Get(s.journalKey(stageName))
+	switch {
+	case !ok || !entry.Succeeded():
+		return ""
+	case s.Once:
+		return fmt.Sprintf("already applied on %s", entry.Time.Format(time.RFC3339))
+	case entry.Hash == s.hash():
+		return fmt.Sprintf("unchanged since %s", entry.Time.Format(time.RFC3339))
+	default:
+		return ""
+	}
+}
+
+// record stores the result of the step in the journal, if the step is marked as once
+// or run on_change. The other steps always run, so they are not recorded.
+func (e *DefaultExecutor) record(l logger.Interface, stageName string, s step, err error) {
+	if e.journal == nil || (!s.Once && s.Run != schema.RunOnChange) {
+		return
+	}
+
+	entry := journal.Entry{
+		Stage:  stageName,
+		Step:   s.label(),
+		Source: s.uri,
+		Hash:   s.hash(),
+		Time:   time.Now().UTC(),
+		Result: journal.ResultSuccess,
+	}
+	if err != nil {
+		entry.Result = journal.ResultFailure
+		entry.Error = e.redactor.Redact(err.Error())
+	}
+	e.journal.Record(s.journalKey(stageName), entry)
+	if err := e.journal.Save(); err != nil {
+		l.Warnf("Failed saving journal: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,136 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultLockPath is the lock file which keeps depcfg runs from overlapping
+const DefaultLockPath = "/run/depcfg.lock"
+
+// errLocksUnsupported is returned when taking a lock on a platform which doesn't support it
+var errLocksUnsupported = errors.New("run locks are only supported on Linux")
+
+// lockPollInterval is how often a run waiting for the lock tries to take it
+const lockPollInterval = 100 * time.Millisecond
+
+// lock takes the run lock, if enabled, waiting for it up to the lock timeout. The lock file
+// is a path of the host, even for the runs applying the configs to an offline root, so that
+// they don't overlap the runs on the host. Dry runs only take a shared lock on the lock file,
+// if it exists, so that they don't overlap the runs applying changes, nor change anything.
+// The run goes on without the lock, with a warning, if the lock file can't be opened or
+// the platform doesn't support locks. The returned function releases it.
+func (e *DefaultExecutor) lock(ctx context.Context) (func(), error) {
+	if e.lockPath == "" {
+		return func() {}, nil
+	}
+	f, err := e.openLock()
+	if err != nil {
+		// e.g. when not run as root, or with a read-only /run
+		e.logger.Warnf("Running without the lock %s: %s\n", e.lockPath, err.Error())
+		return func() {}, nil
+	}
+	if f == nil {
+		return func() {}, nil
+	}
+
+	deadline := time.Now().Add(e.lockTimeout)
+	for waited := false; ; waited = true {
+		locked, err := tryLock(f, e.dryRun)
+		if errors.Is(err, errLocksUnsupported) {
+			f.Close()
+			e.logger.Warnf("Running without the lock %s: %s\n", e.lockPath, err.Error())
+			return func() {}, nil
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("locking %s: %w", e.lockPath, err)
+		}
+		if locked {
+			break
+		}
+
+		holder := lockHolder(f)
+		if !time.Now().Before(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("another run (%s) holds the lock %s, gave up after %s", holder, e.lockPath, e.lockTimeout)
+		}
+		if !waited {
+			e.logger.Warnf("Another run (%s) holds the lock %s, waiting up to %s\n", holder, e.lockPath, e.lockTimeout)
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, fmt.Errorf("waiting for the lock %s: %w", e.lockPath, ctx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+
+	if e.dryRun {
+		return func() {
+			unlockFile(f)
+			f.Close()
+		}, nil
+	}
+	// Record who holds the lock, for the runs waiting for it
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return func() {
+		f.Truncate(0)
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// openLock opens the lock file, creating it unless in a dry run. In a dry run, the
+// file is nil if the lock file doesn't exist.
+func (e *DefaultExecutor) openLock() (*os.File, error) {
+	if e.dryRun {
+		f, err := os.Open(e.lockPath)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return f, err
+	}
+	if err := os.MkdirAll(filepath.Dir(e.lockPath), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(e.lockPath, os.O_RDWR|os.O_CREATE, 0644)
+}
+
+// lockHolder describes the process holding the lock, as it recorded itself in the lock file
+func lockHolder(f *os.File) string {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	if pid := strings.TrimSpace(string(buf[:n])); pid != "" {
+		return "PID " + pid
+	}
+	return "unknown PID"
+}
@@ -0,0 +1,119 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/bhojpur/deploy/pkg/plugins"
+)
+
+// StepPlan holds the changes a single stage step would apply
+type StepPlan struct {
+	Stage   string           `json:"stage"`
+	Config  string           `json:"config,omitempty"`
+	Source  string           `json:"source,omitempty"`
+	Step    string           `json:"step,omitempty"`
+	Changes []plugins.Change `json:"changes"`
+	Errors  []string         `json:"errors,omitempty"`
+}
+
+// Plan is the result of a dry run: the list of changes each step would apply
+type Plan struct {
+	Steps []StepPlan `json:"steps"`
+
+	mu sync.Mutex
+}
+
+func (p *Plan) add(sp StepPlan) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Steps = append(p.Steps, sp)
+}
+
+// Changes returns the number of changes in the plan
+func (p *Plan) Changes() int {
+	n := 0
+	for _, s := range p.Steps {
+		n += len(s.Changes)
+	}
+	return n
+}
+
+// WriteJSON writes the plan as JSON
+func (p *Plan) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p)
+}
+
+// WriteText writes the plan in a human readable form
+func (p *Plan) WriteText(w io.Writer) error {
+	var b strings.Builder
+	for _, s := range p.Steps {
+		name := s.Step
+		if name == "" {
+			name = "<unnamed>"
+		}
+		fmt.Fprintf(&b, "Stage '%s', step '%s'", s.Stage, name)
+		if s.Source != "" {
+			fmt.Fprintf(&b, " (%s)", s.Source)
+		} else if s.Config != "" {
+			fmt.Fprintf(&b, " (%s)", s.Config)
+		}
+		b.WriteString(":\n")
+		if len(s.Changes) == 0 && len(s.Errors) == 0 {
+			b.WriteString("  no changes\n")
+		}
+		for _, c := range s.Changes {
+			fmt.Fprintf(&b, "  %s %s\n", actionSymbol(c.Action), c)
+			for _, line := range strings.SplitAfter(c.Diff, "\n") {
+				if line != "" {
+					b.WriteString("      " + line)
+				}
+			}
+		}
+		for _, e := range s.Errors {
+			fmt.Fprintf(&b, "  ! %s\n", e)
+		}
+	}
+	fmt.Fprintf(&b, "%d change(s) in %d step(s)\n", p.Changes(), len(p.Steps))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func actionSymbol(action string) string {
+	switch action {
+	case plugins.ActionCreate:
+		return "+"
+	case plugins.ActionUpdate:
+		return "~"
+	case plugins.ActionDelete:
+		return "-"
+	default:
+		return "*"
+	}
+}
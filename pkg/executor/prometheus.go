@@ -0,0 +1,160 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Results of the steps in the metrics of a RunReport
+const (
+	resultApplied = "applied"
+	resultSkipped = "skipped"
+	resultFailed  = "failed"
+)
+
+// stageMetrics are the metrics of the runs of a stage in a RunReport
+type stageMetrics struct {
+	finished float64
+	duration float64
+	failed   bool
+	steps    map[string]int
+	plugins  map[string]float64
+}
+
+// WritePrometheus writes the report as metrics in the Prometheus text format, e.g. for
+// the textfile collector of node_exporter. A stage run more than once is summed up.
+func (r *RunReport) WritePrometheus(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stages := map[string]*stageMetrics{}
+	var names []string
+	runFailed := 0
+	for _, st := range r.Stages {
+		m, ok := stages[st.Name]
+		if !ok {
+			m = &stageMetrics{
+				steps:   map[string]int{resultApplied: 0, resultSkipped: 0, resultFailed: 0},
+				plugins: map[string]float64{},
+			}
+			stages[st.Name] = m
+			names = append(names, st.Name)
+		}
+		if !st.Finished.IsZero() {
+			m.finished = float64(st.Finished.UnixNano()) / 1e9
+		}
+		m.duration += st.Duration.Seconds()
+		if st.Status == StatusFailure {
+			m.failed = true
+			runFailed = 1
+		}
+		for _, src := range st.Sources {
+			for _, step := range src.Steps {
+				switch step.Status {
+				case StatusSuccess:
+					m.steps[resultApplied]++
+				case StatusSkipped:
+					m.steps[resultSkipped]++
+				case StatusFailure:
+					m.steps[resultFailed]++
+				}
+				for _, p := range step.Plugins {
+					m.plugins[p.Name] += p.Duration.Seconds()
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+
+	pw := &promWriter{w: w}
+	pw.metric("depcfg_stage_last_run_timestamp_seconds", "Time the stage last finished running, in seconds since the epoch.")
+	for _, n := range names {
+		pw.sample("depcfg_stage_last_run_timestamp_seconds", stages[n].finished, "stage", n)
+	}
+	pw.metric("depcfg_stage_duration_seconds", "Time the stage took to run, in seconds.")
+	for _, n := range names {
+		pw.sample("depcfg_stage_duration_seconds", stages[n].duration, "stage", n)
+	}
+	pw.metric("depcfg_stage_failed", "Whether the stage failed (1) or not (0).")
+	for _, n := range names {
+		failed := 0.0
+		if stages[n].failed {
+			failed = 1
+		}
+		pw.sample("depcfg_stage_failed", failed, "stage", n)
+	}
+	pw.metric("depcfg_steps", "Number of steps of the stage, by result.")
+	for _, n := range names {
+		for _, result := range []string{resultApplied, resultSkipped, resultFailed} {
+			pw.sample("depcfg_steps", float64(stages[n].steps[result]), "stage", n, "result", result)
+		}
+	}
+	pw.metric("depcfg_plugin_duration_seconds", "Time the plugins took to run for the steps of the stage, in seconds.")
+	for _, n := range names {
+		plugins := make([]string, 0, len(stages[n].plugins))
+		for p := range stages[n].plugins {
+			plugins = append(plugins, p)
+		}
+		sort.Strings(plugins)
+		for _, p := range plugins {
+			pw.sample("depcfg_plugin_duration_seconds", stages[n].plugins[p], "stage", n, "plugin", p)
+		}
+	}
+	pw.metric("depcfg_run_failed", "Whether any stage of the last run failed (1) or not (0).")
+	pw.sample("depcfg_run_failed", float64(runFailed))
+	return pw.err
+}
+
+// promWriter writes metrics in the Prometheus text format, keeping the first error
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+// metric writes the help and the type of a gauge
+func (p *promWriter) metric(name, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+// sample writes a value of a metric, with the labels given as name, value pairs
+func (p *promWriter) sample(name string, value float64, labels ...string) {
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+	if len(pairs) > 0 {
+		name += "{" + strings.Join(pairs, ",") + "}"
+	}
+	p.printf("%s %s\n", name, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func (p *promWriter) printf(format string, args ...interface{}) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,139 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+
+	"github.com/bhojpur/deploy/pkg/plugins"
+	"github.com/hashicorp/go-multierror"
+)
+
+// Registry maps plugin names to the plugins, and to the planners describing
+// the changes they would apply. Plugins run in the order they are registered.
+type Registry struct {
+	names   []string
+	entries map[string]RegisteredPlugin
+}
+
+// RegisteredPlugin is a plugin along with its planner, which can be nil
+type RegisteredPlugin struct {
+	Plugin  ContextPlugin
+	Planner Planner
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{entries: map[string]RegisteredPlugin{}}
+}
+
+// DefaultRegistry returns a new Registry with the builtin plugins
+func DefaultRegistry() *Registry {
+	r := NewRegistry()
+	for _, p := range []struct {
+		name    string
+		plugin  ContextPlugin
+		planner Planner
+	}{
+		{"dns", AdaptPlugin(plugins.DNS), plugins.PlanDNS},
+		{"downloads", plugins.DownloadContext, plugins.PlanDownload},
+		{"git", plugins.GitContext, plugins.PlanGit},
+		{"ensure_entities", AdaptPlugin(plugins.Entities), plugins.PlanEntities},
+		{"directories", AdaptPlugin(plugins.EnsureDirectories), plugins.PlanEnsureDirectories},
+		{"files", AdaptPlugin(plugins.EnsureFiles), plugins.PlanEnsureFiles},
+		{"commands", AdaptPlugin(plugins.Commands), plugins.PlanCommands},
+		{"delete_entities", AdaptPlugin(plugins.DeleteEntities), plugins.PlanDeleteEntities},
+		{"hostname", AdaptPlugin(plugins.Hostname), plugins.PlanHostname},
+		{"sysctl", AdaptPlugin(plugins.Sysctl), plugins.PlanSysctl},
+		{"users", AdaptPlugin(plugins.User), plugins.PlanUser},
+		{"authorized_keys", AdaptPlugin(plugins.SSH), plugins.PlanSSH},
+		{"modules", AdaptPlugin(plugins.LoadModules), plugins.PlanLoadModules},
+		{"timesyncd", AdaptPlugin(plugins.Timesyncd), plugins.PlanTimesyncd},
+		{"systemctl", AdaptPlugin(plugins.Systemctl), plugins.PlanSystemctl},
+		{"environment", AdaptPlugin(plugins.Environment), plugins.PlanEnvironment},
+		{"systemd_firstboot", AdaptPlugin(plugins.SystemdFirstboot), plugins.PlanSystemdFirstboot},
+		{"datasource", plugins.DataSourcesContext, plugins.PlanDataSources},
+		{"layout", AdaptPlugin(plugins.Layout), plugins.PlanLayout},
+	} {
+		// The builtin names are unique, registering them can't fail
+		_ = r.Register(p.name, p.plugin, p.planner)
+	}
+	return r
+}
+
+// Register adds a plugin to the registry under name
+func (r *Registry) Register(name string, p ContextPlugin, planner Planner) error {
+	if _, exists := r.entries[name]; exists {
+		return fmt.Errorf("plugin '%s' is already registered", name)
+	}
+	r.names = append(r.names, name)
+	r.entries[name] = RegisteredPlugin{Plugin: p, Planner: planner}
+	return nil
+}
+
+// Lookup returns the plugin registered under name
+func (r *Registry) Lookup(name string) (RegisteredPlugin, bool) {
+	p, ok := r.entries[name]
+	return p, ok
+}
+
+// Names returns the names of the registered plugins, in the order they run
+func (r *Registry) Names() []string {
+	return append([]string{}, r.names...)
+}
+
+// Validate returns an error for each of the names which isn't registered
+func (r *Registry) Validate(names ...string) error {
+	var errs error
+	for _, n := range names {
+		if _, ok := r.entries[n]; !ok {
+			errs = multierror.Append(errs, fmt.Errorf("unknown plugin '%s'", n))
+		}
+	}
+	return errs
+}
+
+// selection returns the registered plugins and planners, restricted to the enabled
+// ones if any is given, and without the disabled ones
+func (r *Registry) selection(enabled, disabled []string) ([]namedPlugin, []namedPlanner) {
+	var selected []namedPlugin
+	var planners []namedPlanner
+	for _, n := range r.names {
+		if (len(enabled) > 0 && !contains(enabled, n)) || contains(disabled, n) {
+			continue
+		}
+		p := r.entries[n]
+		selected = append(selected, namedPlugin{name: n, plugin: p.Plugin})
+		if p.Planner != nil {
+			planners = append(planners, namedPlanner{name: n, planner: p.Planner})
+		}
+	}
+	return selected, planners
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,292 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Statuses of the entries of a RunReport
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+	StatusSkipped = "skipped"
+)
+
+// RunReport is the result of the stages run by an executor: per stage, per
+// source file, per step and per plugin
+type RunReport struct {
+	Stages []*StageReport `json:"stages"`
+
+	mu    sync.Mutex
+	steps map[string]*StepReport
+}
+
+// StageReport is the result of a stage
+type StageReport struct {
+	Name     string          `json:"name"`
+	Status   string          `json:"status"`
+	Duration time.Duration   `json:"duration"`
+	Finished time.Time       `json:"finished"`
+	Error    string          `json:"error,omitempty"`
+	Sources  []*SourceReport `json:"sources"`
+}
+
+// SourceReport is the result of the steps defined in a source. Inline
+// configs have an empty source.
+type SourceReport struct {
+	Source string        `json:"source,omitempty"`
+	Error  string        `json:"error,omitempty"`
+	Steps  []*StepReport `json:"steps"`
+}
+
+// StepReport is the result of a stage step
+type StepReport struct {
+	Name     string          `json:"name"`
+	Status   string          `json:"status"`
+	Reason   string          `json:"reason,omitempty"`
+	Duration time.Duration   `json:"duration"`
+	Error    string          `json:"error,omitempty"`
+	Plugins  []*PluginReport `json:"plugins,omitempty"`
+}
+
+// PluginReport is the result of a plugin run for a step
+type PluginReport struct {
+	Name     string        `json:"name"`
+	Attempt  int           `json:"attempt"`
+	Status   string        `json:"status"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Failed tells if any of the stages failed
+func (r *RunReport) Failed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.Stages {
+		if s.Status == StatusFailure {
+			return true
+		}
+	}
+	return false
+}
+
+// StepStatus returns the status of the latest step with the given label, or an empty
+// string if no step with that label ran
+func (r *RunReport) StepStatus(label string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := len(r.Stages) - 1; i >= 0; i-- {
+		for _, src := range r.Stages[i].Sources {
+			for j := len(src.Steps) - 1; j >= 0; j-- {
+				if src.Steps[j].Name == label {
+					return src.Steps[j].Status
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// handle records an event in the report
+func (r *RunReport) handle(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch e.Type {
+	case EventStageStarted:
+		r.Stages = append(r.Stages, &StageReport{Name: e.Stage, Sources: []*SourceReport{}})
+		r.steps = map[string]*StepReport{}
+	case EventStageFinished:
+		if st := r.stage(); st != nil {
+			st.Duration = e.Duration
+			st.Finished = e.Time
+			st.Error = e.Error
+			st.Status = status(e.Error)
+		}
+	case EventStepStarted, EventStepSkipped:
+		src := r.source(e.Source)
+		if src == nil {
+			return
+		}
+		step := &StepReport{Name: e.Step, Status: StatusSkipped, Reason: e.Reason}
+		src.Steps = append(src.Steps, step)
+		r.steps[stepKey(e)] = step
+	case EventStepFinished:
+		if step := r.steps[stepKey(e)]; step != nil {
+			step.Duration = e.Duration
+			step.Error = e.Error
+			step.Status = status(e.Error)
+		}
+	case EventPluginFinished:
+		if step := r.steps[stepKey(e)]; step != nil {
+			step.Plugins = append(step.Plugins, &PluginReport{
+				Name:     e.Plugin,
+				Attempt:  e.Attempt,
+				Status:   status(e.Error),
+				Duration: e.Duration,
+				Error:    e.Error,
+			})
+		}
+	case EventError:
+		// Errors about a whole source, e.g. failing to load it
+		if e.Step == "" && e.Source != "" {
+			if src := r.source(e.Source); src != nil {
+				src.Error = e.Error
+			}
+		}
+	}
+}
+
+// stepKey identifies the step an event is about in the stage being run, by its position
+// in its source, as several steps can have the same name
+func stepKey(e Event) string {
+	return fmt.Sprintf("%s\x00%d", e.Source, e.Index)
+}
+
+// stage returns the stage being run
+func (r *RunReport) stage() *StageReport {
+	if len(r.Stages) == 0 {
+		return nil
+	}
+	return r.Stages[len(r.Stages)-1]
+}
+
+// source returns the report of source in the stage being run, adding it if needed
+func (r *RunReport) source(source string) *SourceReport {
+	st := r.stage()
+	if st == nil {
+		return nil
+	}
+	for _, s := range st.Sources {
+		if s.Source == source {
+			return s
+		}
+	}
+	s := &SourceReport{Source: source, Steps: []*StepReport{}}
+	st.Sources = append(st.Sources, s)
+	return s
+}
+
+func status(err string) string {
+	if err != "" {
+		return StatusFailure
+	}
+	return StatusSuccess
+}
+
+// WriteJSON writes the report as JSON
+func (r *RunReport) WriteJSON(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Errors   int             `xml:"errors,attr"`
+	Skipped  int             `xml:"skipped,attr"`
+	Time     string          `xml:"time,attr"`
+	Error    *junitMessage   `xml:"error,omitempty"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML. Each source of each stage is a
+// test suite, and each step a test case.
+func (r *RunReport) WriteJUnit(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	suites := junitTestSuites{}
+	for _, st := range r.Stages {
+		for _, src := range st.Sources {
+			name := src.Source
+			if name == "" {
+				name = "<inline>"
+			}
+			suite := junitTestSuite{Name: fmt.Sprintf("%s: %s", st.Name, name)}
+			if src.Error != "" {
+				suite.Errors++
+				suite.Error = &junitMessage{Message: "failed loading the source", Body: src.Error}
+			}
+
+			var total time.Duration
+			for _, step := range src.Steps {
+				tc := junitTestCase{Name: step.Name, ClassName: st.Name, Time: seconds(step.Duration)}
+				switch step.Status {
+				case StatusFailure:
+					suite.Failures++
+					tc.Failure = &junitMessage{Message: "step failed", Body: step.Error}
+				case StatusSkipped:
+					suite.Skipped++
+					tc.Skipped = &junitMessage{Message: step.Reason}
+				}
+				total += step.Duration
+				suite.Cases = append(suite.Cases, tc)
+			}
+			suite.Tests = len(suite.Cases)
+			suite.Time = seconds(total)
+			suites.Suites = append(suites.Suites, suite)
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
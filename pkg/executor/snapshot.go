@@ -0,0 +1,172 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/bhojpur/deploy/pkg/logger"
+	"github.com/bhojpur/deploy/pkg/plugins"
+	"github.com/hashicorp/go-multierror"
+	"github.com/twpayne/go-vfs"
+)
+
+// snapshot holds the original state of the files a transactional step changes, and
+// the hostname of the running system if the step changes it
+type snapshot struct {
+	fs       vfs.FS
+	paths    []pathSnapshot
+	hostname string
+}
+
+type pathSnapshot struct {
+	path    string
+	exists  bool
+	dir     bool
+	content []byte
+	mode    os.FileMode
+	uid     int
+	gid     int
+}
+
+// takeSnapshot saves the files and directories the planners report the step changes.
+// The planners of the plugins the step is not allowed to run are ignored.
+func (e *DefaultExecutor) takeSnapshot(l logger.Interface, stage step, fs vfs.FS, console plugins.Console) (*snapshot, error) {
+	s := &snapshot{fs: fs}
+	seen := map[string]bool{}
+	var errs error
+	for _, p := range e.planners {
+		if !stage.allows(p.name) {
+			continue
+		}
+		changes, err := p.planner(l, stage.Stage, fs, console)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		for _, c := range changes {
+			if c.Kind == plugins.KindHostname && s.hostname == "" {
+				if s.hostname, err = os.Hostname(); err != nil {
+					errs = multierror.Append(errs, err)
+				}
+				continue
+			}
+			if seen[c.Target] || !snapshotted(c) {
+				continue
+			}
+			seen[c.Target] = true
+			ps, err := snapshotPath(c.Target, fs)
+			if err != nil {
+				errs = multierror.Append(errs, err)
+				continue
+			}
+			s.paths = append(s.paths, ps)
+		}
+	}
+	return s, errs
+}
+
+// snapshotted tells if the change touches a path which can be restored
+func snapshotted(c plugins.Change) bool {
+	switch c.Kind {
+	case plugins.KindFile, plugins.KindEntity:
+		return true
+	case plugins.KindDirectory:
+		return c.Action == plugins.ActionCreate
+	}
+	return false
+}
+
+func snapshotPath(path string, fs vfs.FS) (pathSnapshot, error) {
+	ps := pathSnapshot{path: path}
+	info, err := fs.Stat(path)
+	if os.IsNotExist(err) {
+		return ps, nil
+	}
+	if err != nil {
+		return ps, err
+	}
+
+	ps.exists = true
+	ps.dir = info.IsDir()
+	ps.mode = info.Mode()
+	ps.uid, ps.gid = -1, -1
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		ps.uid, ps.gid = int(st.Uid), int(st.Gid)
+	}
+	if !ps.dir {
+		ps.content, err = fs.ReadFile(path)
+	}
+	return ps, err
+}
+
+// restore puts the files and the hostname back as they were when the snapshot was
+// taken, and removes the files which didn't exist
+func (s *snapshot) restore() error {
+	var errs error
+	for i := len(s.paths) - 1; i >= 0; i-- {
+		if err := s.paths[i].restore(s.fs); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("restoring %s: %w", s.paths[i].path, err))
+		}
+	}
+	if s.hostname != "" {
+		if err := syscall.Sethostname([]byte(s.hostname)); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("restoring the hostname: %w", err))
+		}
+	}
+	return errs
+}
+
+func (ps pathSnapshot) restore(fs vfs.FS) error {
+	if !ps.exists {
+		if err := fs.Remove(ps.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if ps.dir {
+		return nil
+	}
+
+	info, err := fs.Stat(ps.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := fs.WriteFile(ps.path, ps.content, ps.mode.Perm()); err != nil {
+		return err
+	}
+	// Files like the ones in /proc/sys can't be chmod-ed, so leave them alone if unchanged
+	if info == nil || info.Mode() != ps.mode {
+		if err := fs.Chmod(ps.path, ps.mode); err != nil {
+			return err
+		}
+	}
+	if ps.uid < 0 {
+		return nil
+	}
+	if info != nil {
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && int(st.Uid) == ps.uid && int(st.Gid) == ps.gid {
+			return nil
+		}
+	}
+	return fs.Chown(ps.path, ps.uid, ps.gid)
+}
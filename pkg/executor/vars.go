@@ -0,0 +1,71 @@
+package executor
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+
+	"github.com/bhojpur/deploy/pkg/logger"
+	"github.com/bhojpur/deploy/pkg/plugins"
+)
+
+// mergeVars merges the variables, the later ones overriding the former
+func mergeVars(vars ...map[string]string) map[string]string {
+	merged := map[string]string{}
+	for _, v := range vars {
+		for key, value := range v {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// newTemplateData gets the template data of a run, shared by all its steps so that they
+// get the same .UUID or .Random
+func (e *DefaultExecutor) newTemplateData() error {
+	data, err := plugins.TemplateData(nil)
+	if err != nil {
+		return err
+	}
+	delete(data, "Vars")
+	e.templateData = data
+	return nil
+}
+
+// setVars gives the steps the variables of the config defining them, their own and the ones
+// of the executor, in order of precedence, along with the template data of the run
+func (e *DefaultExecutor) setVars(steps []step) {
+	for i := range steps {
+		steps[i].Vars = mergeVars(steps[i].vars, steps[i].Vars, e.vars)
+		steps[i].TemplateData = e.templateData
+	}
+}
+
+// render renders the templates in the step, once it is about to run. The plugins
+// render the rest of its fields themselves.
+func (e *DefaultExecutor) render(l logger.Interface, s step) (step, error) {
+	rendered, err := plugins.RenderStage(l, s.Stage)
+	if err != nil {
+		return s, fmt.Errorf("rendering step %s: %w", s, err)
+	}
+	s.Stage = rendered
+	return s, nil
+}
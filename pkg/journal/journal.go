@@ -0,0 +1,155 @@
+package journal
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/twpayne/go-vfs"
+)
+
+// DefaultPath is where depcfg keeps its journal unless told otherwise
+const DefaultPath = "/var/lib/depcfg/journal.json"
+
+// Results of a journal entry
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Entry records the last time a stage step was applied
+type Entry struct {
+	Stage  string    `json:"stage"`
+	Step   string    `json:"step"`
+	Source string    `json:"source,omitempty"`
+	Hash   string    `json:"hash"`
+	Time   time.Time `json:"time"`
+	Result string    `json:"result"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Succeeded tells if the step was applied without errors
+func (e Entry) Succeeded() bool {
+	return e.Result == ResultSuccess
+}
+
+// Journal is the state file recording the stage steps applied on the system,
+// so steps can be run only once or only when their definition changes.
+// It is safe for concurrent use.
+type Journal struct {
+	path string
+	fs   vfs.FS
+
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+// Load reads the journal at path. A missing file is an empty journal.
+func Load(path string, fs vfs.FS) (*Journal, error) {
+	j := &Journal{path: path, fs: fs, entries: map[string]Entry{}}
+
+	b, err := fs.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return j, nil
+	case err != nil:
+		return nil, errors.Wrapf(err, "reading journal %s", path)
+	}
+
+	if err := json.Unmarshal(b, &j.entries); err != nil {
+		return nil, errors.Wrapf(err, "parsing journal %s", path)
+	}
+	return j, nil
+}
+
+// Path returns the file the journal is stored in
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Get returns the entry recorded for key
+func (j *Journal) Get(key string) (Entry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.entries[key]
+	return e, ok
+}
+
+// Record stores the entry for key, replacing any previous one
+func (j *Journal) Record(key string, e Entry) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries[key] = e
+}
+
+// Delete removes the entry for key, returning false if there was none
+func (j *Journal) Delete(key string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, ok := j.entries[key]
+	delete(j.entries, key)
+	return ok
+}
+
+// Reset removes all the entries
+func (j *Journal) Reset() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = map[string]Entry{}
+}
+
+// Keys returns the sorted keys of all the entries
+func (j *Journal) Keys() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	keys := make([]string, 0, len(j.entries))
+	for k := range j.entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Save writes the journal to its file. The file is replaced atomically, so an
+// interrupted run never leaves a truncated journal behind.
+func (j *Journal) Save() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	b, err := json.MarshalIndent(j.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := vfs.MkdirAll(j.fs, filepath.Dir(j.path), 0755); err != nil {
+		return errors.Wrapf(err, "creating journal directory for %s", j.path)
+	}
+	tmp := j.path + ".tmp"
+	if err := j.fs.WriteFile(tmp, b, 0600); err != nil {
+		return errors.Wrapf(err, "writing journal %s", j.path)
+	}
+	return j.fs.Rename(tmp, j.path)
+}
@@ -0,0 +1,70 @@
+package journal_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"time"
+
+	"github.com/bhojpur/deploy/pkg/journal"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/twpayne/go-vfs/vfst"
+)
+
+var _ = Describe("Journal", func() {
+	It("Starts empty when the file is missing", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+		Expect(err).Should(BeNil())
+		defer cleanup()
+
+		j, err := journal.Load("/var/lib/depcfg/journal.json", fs)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(j.Keys()).To(BeEmpty())
+	})
+
+	It("Saves and loads entries", func() {
+		fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+		Expect(err).Should(BeNil())
+		defer cleanup()
+
+		j, err := journal.Load("/var/lib/depcfg/journal.json", fs)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		now := time.Now().UTC().Truncate(time.Second)
+		j.Record("boot/foo", journal.Entry{Stage: "boot", Step: "foo", Hash: "abc", Time: now, Result: journal.ResultSuccess})
+		j.Record("boot/bar", journal.Entry{Stage: "boot", Step: "bar", Hash: "def", Time: now, Result: journal.ResultFailure, Error: "boom"})
+		Expect(j.Save()).To(Succeed())
+
+		j, err = journal.Load("/var/lib/depcfg/journal.json", fs)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(j.Keys()).To(Equal([]string{"boot/bar", "boot/foo"}))
+
+		e, ok := j.Get("boot/foo")
+		Expect(ok).To(BeTrue())
+		Expect(e.Succeeded()).To(BeTrue())
+		Expect(e.Time.Equal(now)).To(BeTrue())
+
+		Expect(j.Delete("boot/foo")).To(BeTrue())
+		Expect(j.Delete("boot/foo")).To(BeFalse())
+		j.Reset()
+		Expect(j.Keys()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,54 @@
+package logger
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import "fmt"
+
+// WithPrefix returns a logger which prepends prefix to every message before
+// passing it to l.
+func WithPrefix(l Interface, prefix string) Interface {
+	return &prefixed{Interface: l, prefix: prefix}
+}
+
+type prefixed struct {
+	Interface
+	prefix string
+}
+
+func (p *prefixed) msg(args []interface{}) string {
+	return p.prefix + fmt.Sprint(args...)
+}
+
+func (p *prefixed) Info(args ...interface{})  { p.Interface.Info(p.msg(args)) }
+func (p *prefixed) Warn(args ...interface{})  { p.Interface.Warn(p.msg(args)) }
+func (p *prefixed) Debug(args ...interface{}) { p.Interface.Debug(p.msg(args)) }
+func (p *prefixed) Error(args ...interface{}) { p.Interface.Error(p.msg(args)) }
+func (p *prefixed) Fatal(args ...interface{}) { p.Interface.Fatal(p.msg(args)) }
+func (p *prefixed) Panic(args ...interface{}) { p.Interface.Panic(p.msg(args)) }
+func (p *prefixed) Trace(args ...interface{}) { p.Interface.Trace(p.msg(args)) }
+
+func (p *prefixed) Infof(f string, args ...interface{})  { p.Interface.Infof(p.prefix+f, args...) }
+func (p *prefixed) Warnf(f string, args ...interface{})  { p.Interface.Warnf(p.prefix+f, args...) }
+func (p *prefixed) Debugf(f string, args ...interface{}) { p.Interface.Debugf(p.prefix+f, args...) }
+func (p *prefixed) Errorf(f string, args ...interface{}) { p.Interface.Errorf(p.prefix+f, args...) }
+func (p *prefixed) Fatalf(f string, args ...interface{}) { p.Interface.Fatalf(p.prefix+f, args...) }
+func (p *prefixed) Panicf(f string, args ...interface{}) { p.Interface.Panicf(p.prefix+f, args...) }
+func (p *prefixed) Tracef(f string, args ...interface{}) { p.Interface.Tracef(p.prefix+f, args...) }
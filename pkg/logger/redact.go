@@ -0,0 +1,58 @@
+package logger
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import "fmt"
+
+// WithRedaction returns a logger which passes every message through redact
+// before passing it to l, e.g. to mask secrets.
+func WithRedaction(l Interface, redact func(string) string) Interface {
+	return &redacted{Interface: l, redact: redact}
+}
+
+type redacted struct {
+	Interface
+	redact func(string) string
+}
+
+func (r *redacted) msg(args []interface{}) string {
+	return r.redact(fmt.Sprint(args...))
+}
+
+func (r *redacted) msgf(f string, args []interface{}) string {
+	return r.redact(fmt.Sprintf(f, args...))
+}
+
+func (r *redacted) Info(args ...interface{})  { r.Interface.Info(r.msg(args)) }
+func (r *redacted) Warn(args ...interface{})  { r.Interface.Warn(r.msg(args)) }
+func (r *redacted) Debug(args ...interface{}) { r.Interface.Debug(r.msg(args)) }
+func (r *redacted) Error(args ...interface{}) { r.Interface.Error(r.msg(args)) }
+func (r *redacted) Fatal(args ...interface{}) { r.Interface.Fatal(r.msg(args)) }
+func (r *redacted) Panic(args ...interface{}) { r.Interface.Panic(r.msg(args)) }
+func (r *redacted) Trace(args ...interface{}) { r.Interface.Trace(r.msg(args)) }
+
+func (r *redacted) Infof(f string, args ...interface{})  { r.Interface.Info(r.msgf(f, args)) }
+func (r *redacted) Warnf(f string, args ...interface{})  { r.Interface.Warn(r.msgf(f, args)) }
+func (r *redacted) Debugf(f string, args ...interface{}) { r.Interface.Debug(r.msgf(f, args)) }
+func (r *redacted) Errorf(f string, args ...interface{}) { r.Interface.Error(r.msgf(f, args)) }
+func (r *redacted) Fatalf(f string, args ...interface{}) { r.Interface.Fatal(r.msgf(f, args)) }
+func (r *redacted) Panicf(f string, args ...interface{}) { r.Interface.Panic(r.msgf(f, args)) }
+func (r *redacted) Tracef(f string, args ...interface{}) { r.Interface.Trace(r.msgf(f, args)) }
@@ -32,7 +32,12 @@ import (
 func Commands(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	var errs error
 	for _, cmd := range s.Commands {
-		out, err := console.Run(templateSysData(l, cmd))
+		cmd, err := templateSysData(l, s, cmd)
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("failed rendering command: %w", err))
+			continue
+		}
+		out, err := console.Run(cmd)
 		if err != nil {
 			l.Error(out, ": ", err.Error())
 			errs = multierror.Append(errs, err)
@@ -42,3 +47,18 @@ func Commands(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) er
 	}
 	return errs
 }
+
+// PlanCommands returns the commands that Commands would run
+func PlanCommands(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	var errs error
+	for _, cmd := range s.Commands {
+		cmd, err := templateSysData(l, s, cmd)
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("failed rendering command: %w", err))
+			continue
+		}
+		changes = append(changes, commandChange("commands", cmd))
+	}
+	return changes, errs
+}
@@ -63,5 +63,26 @@ var _ = Describe("Commands", func() {
 			Expect(err).ShouldNot(HaveOccurred())
 			Expect(consoletests.Commands).Should(Equal([]string{"echo " + arch, "echo bar"}))
 		})
+		It("keeps the commands which aren't templates for it", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+			err = Commands(l, schema.Stage{
+				Vars:     map[string]string{"format": "table"},
+				Commands: []string{"docker ps --format '{{.Names}}'", "echo {{.Vars.format}}"},
+			}, fs, testConsole)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(consoletests.Commands).Should(Equal([]string{"docker ps --format '{{.Names}}'", "echo table"}))
+		})
+		It("doesn't run commands using undefined variables", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+			err = Commands(l, schema.Stage{
+				Commands: []string{"echo {{.Vars.missing}}", "echo bar"},
+			}, fs, testConsole)
+			Expect(err).Should(MatchError(ContainSubstring("undefined variable 'missing'")))
+			Expect(consoletests.Commands).Should(Equal([]string{"echo bar"}))
+		})
 	})
 })
@@ -24,14 +24,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"os/exec"
 	"strings"
 	"time"
 
 	"github.com/bhojpur/deploy/pkg/logger"
+	"github.com/bhojpur/deploy/pkg/schema"
 	"github.com/bhojpur/deploy/pkg/utils"
+	"github.com/denisbrodbeck/machineid"
 	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
 	"github.com/zcalusic/sysinfo"
 )
 
@@ -39,6 +43,7 @@ var system sysinfo.SysInfo
 
 func init() {
 	system.GetSysInfo()
+	rand.Seed(time.Now().UnixNano())
 }
 
 type Console interface {
@@ -47,28 +52,163 @@ type Console interface {
 	RunTemplate([]string, string) error
 }
 
-func templateSysData(l logger.Interface, s string) string {
-	interpolateOpts := map[string]interface{}{}
+// TemplateData returns the data the templates in the fields of a stage step are rendered
+// with: the system data as .Values, the step variables as .Vars, along with UUID, Random
+// and MachineID to generate names, e.g. "node-{{.UUID}}". UUID and Random are generated
+// on each call, so the executor gets the data once per run, see schema.Stage.TemplateData.
+func TemplateData(vars map[string]string) (map[string]interface{}, error) {
+	values, err := systemValues()
+	if err != nil {
+		return nil, err
+	}
+	if vars == nil {
+		vars = map[string]string{}
+	}
+
+	id, _ := machineid.ID()
+	return map[string]interface{}{
+		"Values":    values,
+		"Vars":      vars,
+		"UUID":      uuid.NewV4().String(),
+		"MachineID": id,
+		"Random":    utils.RandomString(32),
+	}, nil
+}
 
+// systemValues returns the system data, as found in .Values by the templates
+func systemValues() (map[string]interface{}, error) {
+	values := map[string]interface{}{}
 	data, err := json.Marshal(&system)
 	if err != nil {
-		l.Warn(fmt.Sprintf("Failed marshalling '%s': %s", s, err.Error()))
-		return s
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// stageData returns the data the templates of the step are rendered with: the data the
+// executor set for the run, or newly generated, with the variables of the step
+func stageData(s schema.Stage) (map[string]interface{}, error) {
+	if s.TemplateData == nil {
+		return TemplateData(s.Vars)
+	}
+	data := make(map[string]interface{}, len(s.TemplateData)+1)
+	for k, v := range s.TemplateData {
+		data[k] = v
 	}
-	l.Debug(string(data))
+	vars := s.Vars
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	data["Vars"] = vars
+	return data, nil
+}
 
-	err = json.Unmarshal(data, &interpolateOpts)
+// checkVars fails if the template t refers to variables missing from the .Vars of data.
+// Templates which can't be parsed are left to fail when rendered.
+func checkVars(t string, data map[string]interface{}) error {
+	if !strings.Contains(t, "{{") {
+		return nil
+	}
+	fields, err := utils.TemplateFields(t, "Vars")
 	if err != nil {
-		l.Warn(fmt.Sprintf("Failed marshalling '%s': %s", s, err.Error()))
-		return s
+		return nil
+	}
+	vars, _ := data["Vars"].(map[string]string)
+	for _, f := range fields {
+		if _, ok := vars[f]; !ok {
+			return fmt.Errorf("undefined variable '%s'", f)
+		}
+	}
+	return nil
+}
+
+// render renders the template t with data. It fails if t refers to undefined variables,
+// other templates failing to render are left as they are, e.g. a docker --format '{{.Names}}'.
+func render(l logger.Interface, t string, data map[string]interface{}) (string, error) {
+	if !strings.Contains(t, "{{") {
+		return t, nil
+	}
+	if err := checkVars(t, data); err != nil {
+		return t, err
+	}
+	rendered, err := utils.StrictTemplatedString(t, data)
+	if err != nil {
+		l.Warn(fmt.Sprintf("Failed rendering '%s', leaving it as is: %s", t, err.Error()))
+		return t, nil
+	}
+	return rendered, nil
+}
+
+// templateSysData renders the template t with the data of the step, see render
+func templateSysData(l logger.Interface, s schema.Stage, t string) (string, error) {
+	if !strings.Contains(t, "{{") {
+		return t, nil
+	}
+	data, err := stageData(s)
+	if err != nil {
+		l.Warn(fmt.Sprintf("Failed getting the template data for '%s': %s", t, err.Error()))
+		return t, nil
+	}
+	return render(l, t, data)
+}
+
+// setPluginFields sets the fields of dst the plugins render themselves to the ones of src:
+// commands, conditions, environment values, entities, hostname and file contents
+func setPluginFields(dst *schema.Stage, src schema.Stage) {
+	dst.Commands, dst.Environment = src.Commands, src.Environment
+	dst.If, dst.OnlyIf, dst.Unless = src.If, src.OnlyIf, src.Unless
+	dst.Node, dst.Match, dst.Hostname = src.Node, src.Match, src.Hostname
+	dst.EnsureEntities, dst.DeleteEntities = src.EnsureEntities, src.DeleteEntities
+	files := make([]schema.File, len(dst.Files))
+	for i, f := range dst.Files {
+		f.Content = ""
+		if i < len(src.Files) {
+			f.Content = src.Files[i].Content
+		}
+		files[i] = f
+	}
+	if dst.Files != nil {
+		dst.Files = files
+	}
+}
+
+// RenderStage renders the templates in the fields of the step the plugins don't render
+// themselves, e.g. paths, urls, users, units or layout labels, with the data of the step.
+// It fails if any field of the step refers to an undefined variable, so that such a step
+// doesn't run at all. The variables are not rendered, nor the content of encoded files.
+func RenderStage(l logger.Interface, s schema.Stage) (schema.Stage, error) {
+	data, err := stageData(s)
+	if err != nil {
+		return s, err
+	}
+
+	checked := s
+	checked.Vars = nil
+	checked.Files = make([]schema.File, len(s.Files))
+	for i, f := range s.Files {
+		if f.Encoding != "" {
+			f.Content = ""
+		}
+		checked.Files[i] = f
+	}
+	if _, err := utils.RenderStrings(checked, func(t string) (string, error) { return t, checkVars(t, data) }); err != nil {
+		return s, err
 	}
 
-	rendered, err := utils.TemplatedString(s, map[string]interface{}{"Values": interpolateOpts})
+	others := s
+	others.Vars = nil
+	setPluginFields(&others, schema.Stage{})
+	rendered, err := utils.RenderStrings(others, func(t string) (string, error) { return render(l, t, data) })
 	if err != nil {
-		l.Warn(fmt.Sprintf("Failed rendering '%s': %s", s, err.Error()))
-		return s
+		return s, err
 	}
-	return rendered
+	stage := rendered.(schema.Stage)
+	stage.Vars = s.Vars
+	setPluginFields(&stage, s)
+	return stage, nil
 }
 
 func download(url string) (string, error) {
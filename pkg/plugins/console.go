@@ -0,0 +1,78 @@
+package plugins
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+
+	"github.com/hashicorp/go-multierror"
+)
+
+// ContextConsole is a Console which can tie the commands it runs to a
+// context, killing them once the context is done.
+type ContextConsole interface {
+	Console
+	RunContext(context.Context, string, ...func(*exec.Cmd)) (string, error)
+	StartContext(context.Context, *exec.Cmd, ...func(*exec.Cmd)) error
+}
+
+// BindConsole returns a Console running all its commands with ctx. Consoles
+// which are not a ContextConsole only get ctx checked before each command.
+func BindConsole(ctx context.Context, c Console) Console {
+	return boundConsole{ctx: ctx, console: c}
+}
+
+type boundConsole struct {
+	ctx     context.Context
+	console Console
+}
+
+func (b boundConsole) Run(cmd string, opts ...func(*exec.Cmd)) (string, error) {
+	if cc, ok := b.console.(ContextConsole); ok {
+		return cc.RunContext(b.ctx, cmd, opts...)
+	}
+	if err := b.ctx.Err(); err != nil {
+		return "", fmt.Errorf("not running %s: %w", cmd, err)
+	}
+	return b.console.Run(cmd, opts...)
+}
+
+func (b boundConsole) Start(cmd *exec.Cmd, opts ...func(*exec.Cmd)) error {
+	if cc, ok := b.console.(ContextConsole); ok {
+		return cc.StartContext(b.ctx, cmd, opts...)
+	}
+	if err := b.ctx.Err(); err != nil {
+		return fmt.Errorf("not running %s: %w", cmd, err)
+	}
+	return b.console.Start(cmd, opts...)
+}
+
+func (b boundConsole) RunTemplate(st []string, template string) error {
+	var errs error
+	for _, svc := range st {
+		if _, err := b.Run(fmt.Sprintf(template, svc)); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
@@ -22,10 +22,12 @@ package plugins
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
 	"os/user"
 	"path"
+	"sort"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -37,11 +39,19 @@ import (
 )
 
 func DataSources(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
+	return DataSourcesContext(context.Background(), l, s, fs, console)
+}
+
+// DataSourcesContext is DataSources, not probing further providers once ctx is done
+func DataSourcesContext(ctx context.Context, l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	var AvailableProviders = []prv.Provider{}
 
 	if s.DataSources.Providers == nil || len(s.DataSources.Providers) == 0 {
 		return nil
 	}
+	if skipOffline(l, fs, "probing the datasources") {
+		return nil
+	}
 
 	for _, dSProviders := range s.DataSources.Providers {
 		switch {
@@ -90,6 +100,9 @@ func DataSources(l logger.Interface, s schema.Stage, fs vfs.FS, console Console)
 	var err error
 	found := false
 	for _, p = range AvailableProviders {
+		if err := ctx.Err(); err != nil {
+			return errors.Wrap(err, "probing datasources")
+		}
 		if p.Probe() {
 			userdata, err = p.Extract()
 			if err != nil {
@@ -219,3 +232,25 @@ func writeToFile(l logger.Interface, filename string, content string, perm uint3
 	}
 	return nil
 }
+
+// PlanDataSources returns the providers DataSources would probe and the paths
+// the extracted user data would be written to
+func PlanDataSources(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if _, ok := OfflineRoot(fs); len(s.DataSources.Providers) == 0 || ok {
+		return nil, nil
+	}
+
+	providers := append([]string{}, s.DataSources.Providers...)
+	sort.Strings(providers)
+	basePath := prv.ConfigPath
+	if s.DataSources.Path != "" {
+		basePath = s.DataSources.Path
+	}
+	return []Change{{
+		Plugin: "datasource",
+		Kind:   KindDatasource,
+		Action: ActionRun,
+		Target: strings.Join(providers, ","),
+		Detail: fmt.Sprintf("user data written to %s", basePath),
+	}}, nil
+}
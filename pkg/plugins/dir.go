@@ -83,3 +83,33 @@ func writePath(l logger.Interface, dir schema.Directory, fs vfs.FS, topLevel boo
 		}
 	}
 }
+
+// PlanEnsureDirectories returns the directories EnsureDirectories would create or modify
+func PlanEnsureDirectories(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	var errs error
+	for _, dir := range s.Directories {
+		inf, err := fs.Stat(dir.Path)
+		switch {
+		case err != nil:
+			changes = append(changes, Change{
+				Plugin: "directories",
+				Kind:   KindDirectory,
+				Action: ActionCreate,
+				Target: dir.Path,
+				Detail: fmt.Sprintf("mode %04o", dir.Permissions),
+			})
+		case !inf.IsDir():
+			errs = multierror.Append(errs, fmt.Errorf("Error, '%s' already exists and it is not a directory", dir.Path))
+		case inf.Mode().Perm() != os.FileMode(dir.Permissions).Perm():
+			changes = append(changes, Change{
+				Plugin: "directories",
+				Kind:   KindDirectory,
+				Action: ActionUpdate,
+				Target: dir.Path,
+				Detail: fmt.Sprintf("mode %04o -> %04o", inf.Mode().Perm(), dir.Permissions),
+			})
+		}
+	}
+	return changes, errs
+}
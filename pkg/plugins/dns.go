@@ -21,6 +21,8 @@ package plugins
 // THE SOFTWARE.
 
 import (
+	"strings"
+
 	"github.com/bhojpur/deploy/pkg/logger"
 	"github.com/bhojpur/deploy/pkg/schema"
 	"github.com/moby/libnetwork/resolvconf"
@@ -42,3 +44,30 @@ func applyDNS(s schema.Stage) error {
 	_, err := resolvconf.Build(path, s.Dns.Nameservers, s.Dns.DnsSearch, s.Dns.DnsOptions)
 	return err
 }
+
+// PlanDNS returns the resolv.conf changes DNS would apply
+func PlanDNS(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if len(s.Dns.Nameservers) == 0 {
+		return nil, nil
+	}
+	path := s.Dns.Path
+	if path == "" {
+		path = "/etc/resolv.conf"
+	}
+
+	content := ""
+	if len(s.Dns.DnsSearch) > 0 {
+		content += "search " + strings.Join(s.Dns.DnsSearch, " ") + "\n"
+	}
+	for _, ns := range s.Dns.Nameservers {
+		content += "nameserver " + ns + "\n"
+	}
+	if len(s.Dns.DnsOptions) > 0 {
+		content += "options " + strings.Join(s.Dns.DnsOptions, " ") + "\n"
+	}
+
+	if c := fileChange("dns", path, content, 0644, fs); c != nil {
+		return []Change{*c}, nil
+	}
+	return nil, nil
+}
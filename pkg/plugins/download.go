@@ -21,13 +21,15 @@ package plugins
 // THE SOFTWARE.
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/bhojpur/deploy/pkg/logger"
 	"github.com/bhojpur/deploy/pkg/schema"
-	"github.com/bhojpur/deploy/pkg/utils"
 	"github.com/cavaliergopher/grab"
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
@@ -48,14 +50,14 @@ func grabClient(timeout int) *grab.Client {
 }
 
 func Download(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
+	return DownloadContext(context.Background(), l, s, fs, console)
+}
+
+// DownloadContext is Download, aborting the transfers once ctx is done
+func DownloadContext(ctx context.Context, l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	var errs error
 	for _, dl := range s.Downloads {
-		d := &dl
-		realPath, err := fs.RawPath(d.Path)
-		if err == nil {
-			d.Path = realPath
-		}
-		if err := downloadFile(l, *d); err != nil {
+		if err := downloadFile(ctx, l, dl, fs); err != nil {
 			log.Error(err.Error())
 			errs = multierror.Append(errs, err)
 			continue
@@ -64,15 +66,19 @@ func Download(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) er
 	return errs
 }
 
-func downloadFile(l logger.Interface, dl schema.Download) error {
+func downloadFile(ctx context.Context, l logger.Interface, dl schema.Download, fs vfs.FS) error {
 	l.Debug("Downloading file ", dl.Path, dl.URL)
 	client := grabClient(dl.Timeout)
 
-	req, err := grab.NewRequest(dl.Path, dl.URL)
+	realPath, err := fs.RawPath(dl.Path)
+	if err != nil {
+		return err
+	}
+	req, err := grab.NewRequest(realPath, dl.URL)
 	if err != nil {
 		return err
 	}
-	resp := client.Do(req)
+	resp := client.Do(req.WithContext(ctx))
 
 	t := time.NewTicker(500 * time.Millisecond)
 	defer t.Stop()
@@ -96,21 +102,43 @@ Loop:
 		return err
 	}
 
-	file := resp.Filename
-	err = os.Chmod(file, os.FileMode(dl.Permissions))
+	// Downloading to a directory names the file after the URL
+	file := dl.Path
+	if resp.Filename != realPath {
+		file = filepath.Join(dl.Path, filepath.Base(resp.Filename))
+	}
+	err = fs.Chmod(file, os.FileMode(dl.Permissions))
 	if err != nil {
 		return err
 
 	}
 
 	if dl.OwnerString != "" {
-		// FIXUP: Doesn't support fs. It reads real /etc/passwd files
-		uid, gid, err := utils.GetUserDataFromString(dl.OwnerString)
+		uid, gid, err := ownerIDs(fs, dl.OwnerString)
 		if err != nil {
 			return errors.Wrap(err, "Failed getting gid")
 		}
-		return os.Chown(dl.Path, uid, gid)
+		return fs.Chown(file, uid, gid)
 	}
 
-	return os.Chown(file, dl.Owner, dl.Group)
+	return fs.Chown(file, dl.Owner, dl.Group)
+}
+
+// PlanDownload returns the files Download would fetch
+func PlanDownload(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	for _, dl := range s.Downloads {
+		action := ActionCreate
+		if _, err := fs.Stat(dl.Path); err == nil {
+			action = ActionUpdate
+		}
+		changes = append(changes, Change{
+			Plugin: "downloads",
+			Kind:   KindFile,
+			Action: action,
+			Target: dl.Path,
+			Detail: fmt.Sprintf("from %s, mode %04o", dl.URL, dl.Permissions),
+		})
+	}
+	return changes, nil
 }
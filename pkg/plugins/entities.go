@@ -21,6 +21,8 @@ package plugins
 // THE SOFTWARE.
 
 import (
+	"strings"
+
 	entities "github.com/bhojpur/deploy/pkg/entities"
 	"github.com/bhojpur/deploy/pkg/logger"
 	"github.com/bhojpur/deploy/pkg/schema"
@@ -31,7 +33,7 @@ import (
 func Entities(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	var errs error
 	if len(s.EnsureEntities) > 0 {
-		if err := ensureEntities(l, s); err != nil {
+		if err := ensureEntities(l, s, fs); err != nil {
 			l.Error(err.Error())
 			errs = multierror.Append(errs, err)
 		}
@@ -42,23 +44,33 @@ func Entities(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) er
 func DeleteEntities(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	var errs error
 	if len(s.DeleteEntities) > 0 {
-		if err := deleteEntities(l, s); err != nil {
+		if err := deleteEntities(l, s, fs); err != nil {
 			errs = multierror.Append(errs, err)
 		}
 	}
 	return errs
 }
 
-func deleteEntities(l logger.Interface, s schema.Stage) error {
+func deleteEntities(l logger.Interface, s schema.Stage, fs vfs.FS) error {
 	var errs error
 	entityParser := entities.Parser{}
 	for _, e := range s.DeleteEntities {
-		decodedE, err := entityParser.ReadEntityFromBytes([]byte(templateSysData(l, e.Entity)))
+		entity, err := templateSysData(l, s, e.Entity)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		decodedE, err := entityParser.ReadEntityFromBytes([]byte(entity))
 		if err != nil {
 			errs = multierror.Append(errs, err)
 			continue
 		}
-		err = decodedE.Delete(e.Path)
+		path, err := entityFile(fs, decodedE.GetKind(), e.Path)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		err = decodedE.Delete(path)
 		if err != nil {
 			errs = multierror.Append(errs, err)
 			continue
@@ -67,16 +79,26 @@ func deleteEntities(l logger.Interface, s schema.Stage) error {
 	return errs
 }
 
-func ensureEntities(l logger.Interface, s schema.Stage) error {
+func ensureEntities(l logger.Interface, s schema.Stage, fs vfs.FS) error {
 	var errs error
 	entityParser := entities.Parser{}
 	for _, e := range s.EnsureEntities {
-		decodedE, err := entityParser.ReadEntityFromBytes([]byte(templateSysData(l, e.Entity)))
+		entity, err := templateSysData(l, s, e.Entity)
 		if err != nil {
 			errs = multierror.Append(errs, err)
 			continue
 		}
-		err = decodedE.Apply(e.Path, false)
+		decodedE, err := entityParser.ReadEntityFromBytes([]byte(entity))
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		path, err := entityFile(fs, decodedE.GetKind(), e.Path)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		err = decodedE.Apply(path, false)
 		if err != nil {
 			errs = multierror.Append(errs, err)
 			continue
@@ -84,3 +106,69 @@ func ensureEntities(l logger.Interface, s schema.Stage) error {
 	}
 	return errs
 }
+
+// PlanEntities returns the entities Entities would ensure
+func PlanEntities(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	return planEntities(l, s, "ensure_entities", ActionUpdate, s.EnsureEntities)
+}
+
+// PlanDeleteEntities returns the entities DeleteEntities would remove
+func PlanDeleteEntities(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	return planEntities(l, s, "delete_entities", ActionDelete, s.DeleteEntities)
+}
+
+func planEntities(l logger.Interface, s schema.Stage, plugin, action string, ee []schema.BhojpurEntity) ([]Change, error) {
+	var changes []Change
+	var errs error
+	entityParser := entities.Parser{}
+	for _, e := range ee {
+		entity, err := templateSysData(l, s, e.Entity)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		decodedE, err := entityParser.ReadEntityFromBytes([]byte(entity))
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		changes = append(changes, Change{
+			Plugin: plugin,
+			Kind:   KindEntity,
+			Action: action,
+			Target: entityPath(decodedE.GetKind(), e.Path),
+			Detail: decodedE.GetKind() + " " + entityName(decodedE),
+		})
+	}
+	return changes, errs
+}
+
+// entityName returns the name of the entity: the first field of its line, as the
+// rest can hold secrets, e.g. the password of a shadow entity
+func entityName(e entities.Entity) string {
+	return strings.SplitN(e.String(), ":", 2)[0]
+}
+
+// entityPath returns the file an entity of the given kind is stored in
+func entityPath(kind, path string) string {
+	switch kind {
+	case entities.UserKind:
+		return entities.UserDefault(path)
+	case entities.ShadowKind:
+		return entities.ShadowDefault(path)
+	case entities.GroupKind:
+		return entities.GroupsDefault(path)
+	case entities.GShadowKind:
+		return entities.GShadowDefault(path)
+	}
+	return path
+}
+
+// entityFile returns the path of the file an entity of the given kind is stored in. Entities
+// apply to the files of the host, unless fs is an offline root and they apply to its own.
+func entityFile(fs vfs.FS, kind, path string) (string, error) {
+	if _, ok := OfflineRoot(fs); !ok {
+		return path, nil
+	}
+	return fs.RawPath(entityPath(kind, path))
+}
@@ -0,0 +1,51 @@
+package plugins_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	. "github.com/bhojpur/deploy/pkg/plugins"
+	"github.com/bhojpur/deploy/pkg/schema"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs/vfst"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Entities", func() {
+	Context("planning", func() {
+		It("shows the kind and the name of the entities only", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			changes, err := PlanEntities(logrus.New(), schema.Stage{
+				EnsureEntities: []schema.BhojpurEntity{{Entity: `kind: "shadow"
+username: "foo"
+password: "s3cr3t"
+`}},
+			}, fs, nil)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(changes).To(HaveLen(1))
+			Expect(changes[0].Detail).To(Equal("shadow foo"))
+		})
+	})
+})
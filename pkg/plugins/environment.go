@@ -76,7 +76,11 @@ func Environment(l logger.Interface, s schema.Stage, fs vfs.FS, console Console)
 
 	env, _ := godotenv.Unmarshal(string(content))
 	for key, val := range s.Environment {
-		env[key] = templateSysData(l, val)
+		rendered, err := templateSysData(l, s, val)
+		if err != nil {
+			return errors.Wrapf(err, "failed rendering environment variable %s", key)
+		}
+		env[key] = rendered
 	}
 
 	p, err := fs.RawPath(environment)
@@ -90,3 +94,37 @@ func Environment(l logger.Interface, s schema.Stage, fs vfs.FS, console Console)
 
 	return fs.Chmod(environment, os.FileMode(envFilePerm))
 }
+
+// PlanEnvironment returns the environment file changes Environment would apply
+func PlanEnvironment(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if len(s.Environment) == 0 {
+		return nil, nil
+	}
+	environment := s.EnvironmentFile
+	if environment == "" {
+		environment = environmentFile
+	}
+
+	env := map[string]string{}
+	if content, err := fs.ReadFile(environment); err == nil {
+		if current, err := godotenv.Unmarshal(string(content)); err == nil {
+			env = current
+		}
+	}
+	for key, val := range s.Environment {
+		rendered, err := templateSysData(l, s, val)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed rendering environment variable %s", key)
+		}
+		env[key] = rendered
+	}
+
+	content, err := godotenv.Marshal(env)
+	if err != nil {
+		return nil, err
+	}
+	if c := fileChange("environment", environment, content+"\n", os.FileMode(envFilePerm), fs); c != nil {
+		return []Change{*c}, nil
+	}
+	return nil, nil
+}
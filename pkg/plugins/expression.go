@@ -0,0 +1,159 @@
+package plugins
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/bhojpur/deploy/pkg/logger"
+	"github.com/bhojpur/deploy/pkg/schema"
+	"github.com/twpayne/go-vfs"
+)
+
+// StepStatus returns the status of a step of the current run by its id or name, or an
+// empty string if it didn't run
+type StepStatus func(string) string
+
+// ExpressionConditional returns a conditional skipping the steps whose only_if expression
+// is false, or whose unless expression is true. Expressions are evaluated in-process, see
+// EvalExpression, with the status of the previous steps given by status.
+func ExpressionConditional(status StepStatus) func(logger.Interface, schema.Stage, vfs.FS, Console) error {
+	return func(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
+		if len(s.OnlyIf) == 0 && len(s.Unless) == 0 {
+			return nil
+		}
+		data, err := stageData(s)
+		if err != nil {
+			return err
+		}
+		if len(s.OnlyIf) > 0 {
+			ok, err := evalExpression(s.OnlyIf, data, fs, status)
+			if err != nil {
+				return fmt.Errorf("Skipping stage (invalid only_if expression: %s)", err.Error())
+			}
+			if !ok {
+				return fmt.Errorf("Skipping stage (only_if expression '%s' is false)", s.OnlyIf)
+			}
+		}
+		if len(s.Unless) > 0 {
+			ok, err := evalExpression(s.Unless, data, fs, status)
+			if err != nil {
+				return fmt.Errorf("Skipping stage (invalid unless expression: %s)", err.Error())
+			}
+			if ok {
+				return fmt.Errorf("Skipping stage (unless expression '%s' is true)", s.Unless)
+			}
+		}
+		return nil
+	}
+}
+
+// EvalExpression evaluates a boolean expression, written as the pipeline of a Go template
+// action, e.g. `and (exists "/etc/hosts") (not (cmdline "nomodeset"))`. It is true if its
+// value is, as for the template if action: false, 0, nil and empty values are false.
+//
+// Expressions get the data of the step templates (.Values, .Vars, ...) and the functions:
+//
+//	exists PATH         whether PATH exists
+//	env NAME            the value of the environment variable NAME
+//	hasEnv NAME         whether the environment variable NAME is set, even if empty
+//	cmdline ARG         whether the kernel command line has ARG, either as a flag or a
+//	                    key=value pair, or has a key=value pair for the key ARG
+//	cmdlineValue KEY    the value of KEY on the kernel command line
+//	step ID             the status of the step ID or named ID in the current run:
+//	                    success, failure, skipped, or empty if it didn't run
+//	contains S SUBSTR   whether S contains SUBSTR
+func EvalExpression(expr string, vars map[string]string, fs vfs.FS, status StepStatus) (bool, error) {
+	data, err := TemplateData(vars)
+	if err != nil {
+		return false, err
+	}
+	return evalExpression(expr, data, fs, status)
+}
+
+// evalExpression is EvalExpression, with the template data of a step
+func evalExpression(expr string, data map[string]interface{}, fs vfs.FS, status StepStatus) (bool, error) {
+	funcs := template.FuncMap{
+		"exists": func(path string) bool {
+			_, err := fs.Stat(path)
+			return err == nil
+		},
+		"env": os.Getenv,
+		"hasEnv": func(name string) bool {
+			_, ok := os.LookupEnv(name)
+			return ok
+		},
+		"cmdline": func(arg string) (bool, error) {
+			args, err := kernelCmdline(fs)
+			if err != nil {
+				return false, err
+			}
+			for _, a := range args {
+				if a == arg || strings.HasPrefix(a, arg+"=") {
+					return true, nil
+				}
+			}
+			return false, nil
+		},
+		"cmdlineValue": func(key string) (string, error) {
+			args, err := kernelCmdline(fs)
+			if err != nil {
+				return "", err
+			}
+			for _, a := range args {
+				if strings.HasPrefix(a, key+"=") {
+					return strings.Trim(strings.TrimPrefix(a, key+"="), `"`), nil
+				}
+			}
+			return "", nil
+		},
+		"step": func(id string) string {
+			if status == nil {
+				return ""
+			}
+			return status(id)
+		},
+		"contains": strings.Contains,
+	}
+
+	t, err := template.New("expression").Funcs(funcs).Option("missingkey=error").
+		Parse("{{ if " + expr + " }}true{{ else }}false{{ end }}")
+	if err != nil {
+		return false, err
+	}
+	var out strings.Builder
+	if err := t.Execute(&out, data); err != nil {
+		return false, err
+	}
+	return out.String() == "true", nil
+}
+
+// kernelCmdline returns the arguments of the kernel command line
+func kernelCmdline(fs vfs.FS) ([]string, error) {
+	b, err := fs.ReadFile("/proc/cmdline")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(b)), nil
+}
@@ -0,0 +1,95 @@
+package plugins_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"os"
+
+	. "github.com/bhojpur/deploy/pkg/plugins"
+	"github.com/bhojpur/deploy/pkg/schema"
+	consoletests "github.com/bhojpur/deploy/tests/console"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs/vfst"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Expressions", func() {
+	Context("evaluating", func() {
+		testConsole := consoletests.TestConsole{}
+		status := func(id string) string {
+			return map[string]string{"setup": "success", "broken": "failure"}[id]
+		}
+		BeforeEach(func() {
+			consoletests.Reset()
+		})
+
+		It("evaluates the helpers without running commands", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/etc/hosts":    "127.0.0.1 boo",
+				"/proc/cmdline": "BOOT_IMAGE=/vmlinuz quiet console=ttyS0,115200 root=LABEL=root",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+			os.Setenv("EXPRESSION_TEST", "")
+			defer os.Unsetenv("EXPRESSION_TEST")
+
+			for expr, expected := range map[string]bool{
+				`exists "/etc/hosts"`:                                     true,
+				`exists "/etc/nope"`:                                      false,
+				`hasEnv "EXPRESSION_TEST"`:                                true,
+				`env "EXPRESSION_TEST"`:                                   false,
+				`cmdline "quiet"`:                                         true,
+				`cmdline "console"`:                                       true,
+				`cmdline "console=tty0"`:                                  false,
+				`eq (cmdlineValue "root") "LABEL=root"`:                   true,
+				`eq (step "setup") "success"`:                             true,
+				`and (eq (step "broken") "failure") (not (step "other"))`: true,
+				`eq .Vars.role "server"`:                                  true,
+				`contains .Values.os.architecture ""`:                     true,
+			} {
+				ok, err := EvalExpression(expr, map[string]string{"role": "server"}, fs, status)
+				Expect(err).ShouldNot(HaveOccurred(), expr)
+				Expect(ok).To(Equal(expected), expr)
+			}
+
+			_, err = EvalExpression(`nope "x"`, nil, fs, status)
+			Expect(err).Should(HaveOccurred())
+			_, err = EvalExpression(`.Vars.missing`, nil, fs, status)
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("skips steps according to only_if and unless", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/etc/hosts": "127.0.0.1 boo"})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			conditional := ExpressionConditional(status)
+			Expect(conditional(logrus.New(), schema.Stage{OnlyIf: `exists "/etc/hosts"`}, fs, testConsole)).To(Succeed())
+			Expect(conditional(logrus.New(), schema.Stage{OnlyIf: `exists "/etc/nope"`}, fs, testConsole)).ToNot(Succeed())
+			Expect(conditional(logrus.New(), schema.Stage{Unless: `eq (step "broken") "failure"`}, fs, testConsole)).ToNot(Succeed())
+			Expect(conditional(logrus.New(), schema.Stage{Unless: `step "other"`}, fs, testConsole)).To(Succeed())
+			Expect(conditional(logrus.New(), schema.Stage{OnlyIf: `(`}, fs, testConsole)).To(MatchError(ContainSubstring("invalid only_if expression")))
+			Expect(consoletests.Commands).To(BeEmpty())
+		})
+	})
+})
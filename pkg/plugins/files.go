@@ -26,7 +26,6 @@ import (
 
 	"github.com/bhojpur/deploy/pkg/logger"
 	"github.com/bhojpur/deploy/pkg/schema"
-	"github.com/bhojpur/deploy/pkg/utils"
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	"github.com/twpayne/go-vfs"
@@ -35,7 +34,7 @@ import (
 func EnsureFiles(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	var errs error
 	for _, file := range s.Files {
-		if err := writeFile(l, file, fs, console); err != nil {
+		if err := writeFile(l, s, file, fs, console); err != nil {
 			l.Error(err.Error())
 			errs = multierror.Append(errs, err)
 			continue
@@ -44,10 +43,15 @@ func EnsureFiles(l logger.Interface, s schema.Stage, fs vfs.FS, console Console)
 	return errs
 }
 
-func writeFile(l logger.Interface, file schema.File, fs vfs.FS, console Console) error {
+func writeFile(l logger.Interface, s schema.Stage, file schema.File, fs vfs.FS, console Console) error {
 	l.Debug("Creating file ", file.Path)
+	content, err := fileContent(l, s, file)
+	if err != nil {
+		return err
+	}
+
 	parentDir := filepath.Dir(file.Path)
-	_, err := fs.Stat(parentDir)
+	_, err = fs.Stat(parentDir)
 	if err != nil {
 		l.Debug("Creating parent directories")
 		perm := file.Permissions
@@ -75,13 +79,7 @@ func writeFile(l logger.Interface, file schema.File, fs vfs.FS, console Console)
 	}
 	defer fsfile.Close()
 
-	d := newDecoder(file.Encoding)
-	c, err := d.Decode(file.Content)
-	if err != nil {
-		return errors.Wrapf(err, "failed decoding content with encoding %s", file.Encoding)
-	}
-
-	_, err = fsfile.WriteString(templateSysData(l, string(c)))
+	_, err = fsfile.WriteString(content)
 	if err != nil {
 		return err
 
@@ -93,8 +91,7 @@ func writeFile(l logger.Interface, file schema.File, fs vfs.FS, console Console)
 	}
 
 	if file.OwnerString != "" {
-		// FIXUP: Doesn't support fs. It reads real /etc/passwd files
-		uid, gid, err := utils.GetUserDataFromString(file.OwnerString)
+		uid, gid, err := ownerIDs(fs, file.OwnerString)
 		if err != nil {
 			return errors.Wrap(err, "Failed getting gid")
 		}
@@ -103,3 +100,45 @@ func writeFile(l logger.Interface, file schema.File, fs vfs.FS, console Console)
 
 	return fs.Chown(file.Path, file.Owner, file.Group)
 }
+
+// fileContent returns the content of the file, rendered with the data of the step. The
+// content of encoded files is decoded instead, and never rendered.
+func fileContent(l logger.Interface, s schema.Stage, file schema.File) (string, error) {
+	if file.Encoding != "" {
+		c, err := newDecoder(file.Encoding).Decode(file.Content)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed decoding content with encoding %s", file.Encoding)
+		}
+		return string(c), nil
+	}
+	content, err := templateSysData(l, s, file.Content)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed rendering the content of %s", file.Path)
+	}
+	return content, nil
+}
+
+// PlanEnsureFiles returns the files EnsureFiles would create or modify
+func PlanEnsureFiles(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	var errs error
+	for _, file := range s.Files {
+		content, err := fileContent(l, s, file)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		if _, err := fs.Stat(filepath.Dir(file.Path)); err != nil {
+			changes = append(changes, Change{
+				Plugin: "files",
+				Kind:   KindDirectory,
+				Action: ActionCreate,
+				Target: filepath.Dir(file.Path),
+			})
+		}
+		if change := fileChange("files", file.Path, content, os.FileMode(file.Permissions), fs); change != nil {
+			changes = append(changes, *change)
+		}
+	}
+	return changes, errs
+}
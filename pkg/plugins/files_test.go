@@ -80,4 +80,30 @@ var _ = Describe("Files", func() {
 			Expect(string(b)).Should(Equal("Test"))
 		})
 	})
+	Context("planning", func() {
+		testConsole := consoletests.TestConsole{}
+		l := logrus.New()
+		It("reports new and changed files without writing them", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{"/tmp/test/bar": &vfst.File{Perm: 0644, Contents: []byte("boo\n")}})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			changes, err := PlanEnsureFiles(l, schema.Stage{
+				Files: []schema.File{
+					{Path: "/tmp/test/bar", Content: "boo\n", Permissions: 0644},
+					{Path: "/tmp/test/baz", Content: "baz\n", Permissions: 0644},
+					{Path: "/tmp/other/foo", Content: "foo\n", Permissions: 0600},
+				},
+			}, fs, testConsole)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(changes).To(Equal([]Change{
+				{Plugin: "files", Kind: KindFile, Action: ActionCreate, Target: "/tmp/test/baz", Detail: "mode 0644", Diff: "+baz\n"},
+				{Plugin: "files", Kind: KindDirectory, Action: ActionCreate, Target: "/tmp/other"},
+				{Plugin: "files", Kind: KindFile, Action: ActionCreate, Target: "/tmp/other/foo", Detail: "mode 0600", Diff: "+foo\n"},
+			}))
+
+			_, err = fs.Stat("/tmp/test/baz")
+			Expect(err).Should(HaveOccurred())
+		})
+	})
 })
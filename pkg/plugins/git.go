@@ -21,6 +21,7 @@ package plugins
 // THE SOFTWARE.
 
 import (
+	"context"
 	"path/filepath"
 
 	"github.com/bhojpur/deploy/pkg/logger"
@@ -37,6 +38,11 @@ import (
 )
 
 func Git(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
+	return GitContext(context.Background(), l, s, fs, console)
+}
+
+// GitContext is Git, aborting the clone or the pull once ctx is done
+func GitContext(ctx context.Context, l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	if s.Git.URL == "" {
 		return nil
 	}
@@ -67,7 +73,7 @@ func Git(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 			return err
 		}
 
-		err = w.Pull(&git.PullOptions{
+		err = w.PullContext(ctx, &git.PullOptions{
 			Auth:            authMethod(s),
 			SingleBranch:    s.Git.BranchOnly,
 			Force:           true,
@@ -96,7 +102,7 @@ func Git(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 
 	applyOptions(s, opts)
 
-	_, err = git.PlainClone(path, false, opts)
+	_, err = git.PlainCloneContext(ctx, path, false, opts)
 	if err != nil {
 		return errors.Wrap(err, "failed cloning repo")
 	}
@@ -155,3 +161,27 @@ func applyOptions(s schema.Stage, g *git.CloneOptions) {
 		g.SingleBranch = true
 	}
 }
+
+// PlanGit returns the repository Git would clone or update
+func PlanGit(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if s.Git.URL == "" {
+		return nil, nil
+	}
+
+	branch := "master"
+	if s.Git.Branch != "" {
+		branch = s.Git.Branch
+	}
+
+	action := ActionCreate
+	if _, err := fs.Stat(filepath.Join(s.Git.Path, ".git")); err == nil {
+		action = ActionUpdate
+	}
+	return []Change{{
+		Plugin: "git",
+		Kind:   KindRepository,
+		Action: action,
+		Target: s.Git.Path,
+		Detail: s.Git.URL + "@" + branch,
+	}}, nil
+}
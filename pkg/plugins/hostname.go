@@ -23,17 +23,12 @@ package plugins
 import (
 	"bufio"
 	"fmt"
-	"math/rand"
 	"strings"
 	"syscall"
-	"time"
 
 	"github.com/bhojpur/deploy/pkg/logger"
 	"github.com/bhojpur/deploy/pkg/schema"
-	"github.com/bhojpur/deploy/pkg/utils"
-	"github.com/denisbrodbeck/machineid"
 	"github.com/hashicorp/go-multierror"
-	uuid "github.com/satori/go.uuid"
 	"github.com/twpayne/go-vfs"
 )
 
@@ -41,39 +36,24 @@ const localHost = "127.0.0.1"
 
 func Hostname(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	var errs error
-	hostname := s.Hostname
-	if hostname == "" {
+	if s.Hostname == "" {
 		return nil
 	}
-
-	// Template the input string with random generated strings and UUID.
-	// Those can be used to e.g. generate random node names based on patterns "foo-{{.UUID}}"
-	rand.Seed(time.Now().UnixNano())
-
-	id, _ := machineid.ID()
-	myuuid := uuid.NewV4()
-	tmpl, err := utils.TemplatedString(hostname,
-		struct {
-			UUID      string
-			Random    string
-			MachineID string
-		}{
-			UUID:      myuuid.String(),
-			MachineID: id,
-			Random:    utils.RandomString(32),
-		},
-	)
+	// Templates can generate names, e.g. "node-{{.UUID}}"
+	hostname, err := templateSysData(l, s, s.Hostname)
 	if err != nil {
 		return err
 	}
 
-	if err := syscall.Sethostname([]byte(tmpl)); err != nil {
-		errs = multierror.Append(errs, err)
+	if !skipOffline(l, fs, "setting the hostname of the running system") {
+		if err := syscall.Sethostname([]byte(hostname)); err != nil {
+			errs = multierror.Append(errs, err)
+		}
 	}
-	if err := SystemHostname(tmpl, fs); err != nil {
+	if err := SystemHostname(hostname, fs); err != nil {
 		errs = multierror.Append(errs, err)
 	}
-	if err := UpdateHostsFile(tmpl, fs); err != nil {
+	if err := UpdateHostsFile(hostname, fs); err != nil {
 		errs = multierror.Append(errs, err)
 	}
 	return errs
@@ -103,3 +83,22 @@ func UpdateHostsFile(hostname string, fs vfs.FS) error {
 func SystemHostname(hostname string, fs vfs.FS) error {
 	return fs.WriteFile("/etc/hostname", []byte(hostname+"\n"), 0644)
 }
+
+// PlanHostname returns the changes Hostname would apply
+func PlanHostname(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if s.Hostname == "" {
+		return nil, nil
+	}
+	hostname, err := templateSysData(l, s, s.Hostname)
+	if err != nil {
+		return nil, err
+	}
+	changes := []Change{
+		{Plugin: "hostname", Kind: KindFile, Action: ActionUpdate, Target: "/etc/hostname"},
+		{Plugin: "hostname", Kind: KindFile, Action: ActionUpdate, Target: "/etc/hosts"},
+	}
+	if _, ok := OfflineRoot(fs); ok {
+		return changes, nil
+	}
+	return append([]Change{{Plugin: "hostname", Kind: KindHostname, Action: ActionUpdate, Target: hostname}}, changes...), nil
+}
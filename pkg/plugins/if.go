@@ -30,7 +30,11 @@ import (
 
 func IfConditional(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	if len(s.If) > 0 {
-		out, err := console.Run(templateSysData(l, s.If))
+		cmd, err := templateSysData(l, s, s.If)
+		if err != nil {
+			return fmt.Errorf("Skipping stage (failed rendering if statement: %s)", err.Error())
+		}
+		out, err := console.Run(cmd)
 		if err != nil {
 			return fmt.Errorf("Skipping stage (if statement didn't passed)")
 		}
@@ -73,7 +73,7 @@ const (
 )
 
 func Layout(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
-	if s.Layout.Device == nil {
+	if s.Layout.Device == nil || skipOffline(l, fs, "partitioning the disks") {
 		return nil
 	}
 
@@ -778,3 +778,44 @@ func (mkfs MkfsCall) Apply(console Console) (string, error) {
 func MiBToSectors(size uint, sectorSize uint) uint {
 	return size * 1048576 / sectorSize
 }
+
+// PlanLayout returns the partitioning Layout would apply. Disks are not probed,
+// so changes are reported even if partitions with the same labels already exist.
+func PlanLayout(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if _, ok := OfflineRoot(fs); s.Layout.Device == nil || ok {
+		return nil, nil
+	}
+
+	target := strings.TrimSpace(s.Layout.Device.Label)
+	if target == "" {
+		target = strings.TrimSpace(s.Layout.Device.Path)
+	}
+	if target == "" {
+		return nil, nil
+	}
+
+	var changes []Change
+	if s.Layout.Expand != nil {
+		changes = append(changes, Change{
+			Plugin: "layout",
+			Kind:   KindDisk,
+			Action: ActionUpdate,
+			Target: target,
+			Detail: fmt.Sprintf("expand last partition to %d MiB", s.Layout.Expand.Size),
+		})
+	}
+	for _, part := range s.Layout.Parts {
+		fsType := part.FileSystem
+		if fsType == "" {
+			fsType = "ext2"
+		}
+		changes = append(changes, Change{
+			Plugin: "layout",
+			Kind:   KindDisk,
+			Action: ActionCreate,
+			Target: target,
+			Detail: fmt.Sprintf("partition %s (%s, %d MiB)", part.FSLabel, fsType, part.Size),
+		})
+	}
+	return changes, nil
+}
@@ -0,0 +1,150 @@
+package plugins
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bhojpur/deploy/pkg/logger"
+	"github.com/bhojpur/deploy/pkg/schema"
+	"github.com/twpayne/go-vfs"
+)
+
+// comparisons are the suffixes of the match criteria comparing numbers
+var comparisons = map[string]struct {
+	text    string
+	compare func(a, b float64) bool
+}{
+	"_gt": {"greater than", func(a, b float64) bool { return a > b }},
+	"_ge": {"greater than or equal to", func(a, b float64) bool { return a >= b }},
+	"_lt": {"less than", func(a, b float64) bool { return a < b }},
+	"_le": {"less than or equal to", func(a, b float64) bool { return a <= b }},
+}
+
+// MatchConditional skips the steps whose match criteria don't all match the system data.
+// Criteria are keyed by the path of a system fact, as found in .Values by the templates,
+// e.g. os.vendor, and match its value against a regexp. Keys with the _gt, _ge, _lt or _le
+// suffix compare numeric facts instead, e.g. memory.size_gt: 4096.
+func MatchConditional(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
+	if len(s.Match) == 0 {
+		return nil
+	}
+	values, err := systemValues()
+	if err != nil {
+		return err
+	}
+	criteria := make(map[string]string, len(s.Match))
+	for key, expected := range s.Match {
+		if criteria[key], err = templateSysData(l, s, expected); err != nil {
+			return fmt.Errorf("Skipping stage (failed rendering match criteria %s: %s)", key, err.Error())
+		}
+	}
+	if mismatches := matchFacts(values, criteria); len(mismatches) > 0 {
+		return fmt.Errorf("Skipping stage (%s)", strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+// matchFacts returns why each of the criteria not matching the facts doesn't match
+func matchFacts(facts map[string]interface{}, criteria map[string]string) []string {
+	keys := make([]string, 0, len(criteria))
+	for k := range criteria {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var mismatches []string
+	for _, key := range keys {
+		expected := criteria[key]
+		path := key
+		var op string
+		for suffix := range comparisons {
+			if strings.HasSuffix(key, suffix) {
+				path, op = strings.TrimSuffix(key, suffix), suffix
+			}
+		}
+
+		// Facts which couldn't be collected are missing, and match as empty strings
+		value, found := fact(facts, path)
+
+		if op == "" {
+			matched, err := regexp.MatchString(expected, value)
+			switch {
+			case err != nil:
+				mismatches = append(mismatches, fmt.Sprintf("invalid regex '%s' for %s: %s", expected, path, err.Error()))
+			case !matched && !found:
+				mismatches = append(mismatches, fmt.Sprintf("%s is unknown, doesn't match '%s'", path, expected))
+			case !matched:
+				mismatches = append(mismatches, fmt.Sprintf("%s '%s' doesn't match '%s'", path, value, expected))
+			}
+			continue
+		}
+
+		c := comparisons[op]
+		if !found {
+			mismatches = append(mismatches, fmt.Sprintf("%s is unknown, can't compare it to %s", path, expected))
+			continue
+		}
+		a, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s '%s' is not a number", path, value))
+			continue
+		}
+		b, err := strconv.ParseFloat(expected, 64)
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("invalid number '%s' for %s", expected, key))
+			continue
+		}
+		if !c.compare(a, b) {
+			mismatches = append(mismatches, fmt.Sprintf("%s %s is not %s %s", path, value, c.text, expected))
+		}
+	}
+	return mismatches
+}
+
+// fact returns the value of the fact at the dotted path, formatted as a string, and
+// whether it was found
+func fact(facts map[string]interface{}, path string) (string, bool) {
+	var v interface{} = facts
+	for _, k := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if v, ok = m[k]; !ok {
+			return "", false
+		}
+	}
+	switch value := v.(type) {
+	case string:
+		return value, true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(value), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,79 @@
+package plugins_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"regexp"
+
+	. "github.com/bhojpur/deploy/pkg/plugins"
+	"github.com/bhojpur/deploy/pkg/schema"
+	consoletests "github.com/bhojpur/deploy/tests/console"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs/vfst"
+	"github.com/zcalusic/sysinfo"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Match", func() {
+	Context("matching facts", func() {
+		testConsole := consoletests.TestConsole{}
+		var si sysinfo.SysInfo
+		si.GetSysInfo()
+		hostname := "^" + regexp.QuoteMeta(si.Node.Hostname) + "$"
+
+		It("runs steps matching every criterion", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			err = MatchConditional(logrus.New(), schema.Stage{
+				Match: map[string]string{"node.hostname": hostname, "product.serial": "^$"},
+			}, fs, testConsole)
+			Expect(err).ShouldNot(HaveOccurred())
+		})
+
+		It("explains which criteria skip the step", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			err = MatchConditional(logrus.New(), schema.Stage{
+				Match: map[string]string{
+					"node.hostname":    hostname,
+					"node.machineid":   "^nope$",
+					"node.hostname_gt": "1",
+					"os.nope_lt":       "1",
+				},
+			}, fs, testConsole)
+			machineID := "node.machineid is unknown, doesn't match '^nope$'"
+			if si.Node.MachineID != "" {
+				machineID = "node.machineid '" + si.Node.MachineID + "' doesn't match '^nope$'"
+			}
+			Expect(err).To(MatchError(
+				"Skipping stage (node.hostname '" + si.Node.Hostname + "' is not a number, " +
+					machineID + ", " +
+					"os.nope is unknown, can't compare it to 1)",
+			))
+		})
+	})
+})
@@ -57,7 +57,7 @@ func loadedModules(l logger.Interface, fs vfs.FS) map[string]interface{} {
 func LoadModules(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	var errs error
 
-	if len(s.Modules) == 0 {
+	if len(s.Modules) == 0 || skipOffline(l, fs, "loading kernel modules") {
 		return nil
 	}
 
@@ -77,3 +77,20 @@ func LoadModules(l logger.Interface, s schema.Stage, fs vfs.FS, console Console)
 	}
 	return errs
 }
+
+// PlanLoadModules returns the kernel modules LoadModules would load
+func PlanLoadModules(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if _, ok := OfflineRoot(fs); len(s.Modules) == 0 || ok {
+		return nil, nil
+	}
+
+	var changes []Change
+	loaded := loadedModules(l, fs)
+	for _, m := range s.Modules {
+		if _, ok := loaded[m]; ok {
+			continue
+		}
+		changes = append(changes, Change{Plugin: "modules", Kind: KindModule, Action: ActionCreate, Target: m})
+	}
+	return changes, nil
+}
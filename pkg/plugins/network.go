@@ -32,12 +32,16 @@ import (
 
 func NodeConditional(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
 	if len(s.Node) > 0 {
-		matched, err := regexp.MatchString(s.Node, system.Node.Hostname)
+		node, err := templateSysData(l, s, s.Node)
+		if err != nil {
+			return fmt.Errorf("Skipping stage (failed rendering node: %s)", err.Error())
+		}
+		matched, err := regexp.MatchString(node, system.Node.Hostname)
 		if !matched {
-			return fmt.Errorf("Skipping stage (node hostname '%s' doesn't match '%s')", system.Node.Hostname, s.Node)
+			return fmt.Errorf("Skipping stage (node hostname '%s' doesn't match '%s')", system.Node.Hostname, node)
 		}
 		if err != nil {
-			return errors.Wrapf(err, "Skipping invalid regex for node hostname '%s', error: %s", s.Node, err.Error())
+			return errors.Wrapf(err, "Skipping invalid regex for node hostname '%s', error: %s", node, err.Error())
 		}
 	}
 	return nil
@@ -0,0 +1,105 @@
+package plugins
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/bhojpur/deploy/pkg/utils"
+	"github.com/twpayne/go-vfs"
+)
+
+// Kinds of resources a plugin can change
+const (
+	KindFile       = "file"
+	KindDirectory  = "directory"
+	KindCommand    = "command"
+	KindEntity     = "entity"
+	KindUser       = "user"
+	KindHostname   = "hostname"
+	KindModule     = "module"
+	KindRepository = "repository"
+	KindDatasource = "datasource"
+	KindDisk       = "disk"
+)
+
+// Actions a plugin can perform on a resource
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+	ActionRun    = "run"
+)
+
+// Change describes a single modification a plugin would apply to the system.
+// Changes are collected in dry-run mode instead of touching the system.
+type Change struct {
+	Plugin string `json:"plugin"`
+	Kind   string `json:"kind"`
+	Action string `json:"action"`
+	Target string `json:"target"`
+	Detail string `json:"detail,omitempty"`
+	Diff   string `json:"diff,omitempty"`
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("%s %s %s", c.Action, c.Kind, c.Target)
+	if c.Detail != "" {
+		s += fmt.Sprintf(" (%s)", c.Detail)
+	}
+	return s
+}
+
+// fileChange returns the change needed to turn the file at path into content
+// with the given permissions. It returns nil if the file already matches.
+func fileChange(plugin, path, content string, perm os.FileMode, fs vfs.FS) *Change {
+	current, err := fs.ReadFile(path)
+	if err != nil {
+		return &Change{
+			Plugin: plugin,
+			Kind:   KindFile,
+			Action: ActionCreate,
+			Target: path,
+			Detail: fmt.Sprintf("mode %04o", perm),
+			Diff:   utils.Diff("", content),
+		}
+	}
+
+	c := &Change{
+		Plugin: plugin,
+		Kind:   KindFile,
+		Action: ActionUpdate,
+		Target: path,
+		Diff:   utils.Diff(string(current), content),
+	}
+	if info, err := fs.Stat(path); err == nil && info.Mode().Perm() != perm.Perm() {
+		c.Detail = fmt.Sprintf("mode %04o -> %04o", info.Mode().Perm(), perm.Perm())
+	}
+	if c.Diff == "" && c.Detail == "" {
+		return nil
+	}
+	return c
+}
+
+func commandChange(plugin, cmd string) Change {
+	return Change{Plugin: plugin, Kind: KindCommand, Action: ActionRun, Target: cmd}
+}
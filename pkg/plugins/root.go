@@ -0,0 +1,147 @@
+package plugins
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"strconv"
+	"strings"
+
+	entities "github.com/bhojpur/deploy/pkg/entities"
+	"github.com/bhojpur/deploy/pkg/logger"
+	"github.com/bhojpur/deploy/pkg/schema"
+	"github.com/bhojpur/deploy/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/twpayne/go-vfs"
+	passwd "github.com/willdonnelly/passwd"
+)
+
+// RootFS is a vfs.FS confined to the root of an offline system, like a mounted disk
+// image or a chroot. The plugins applying to a RootFS skip the actions which would
+// change the running host instead, and look up users and groups in its own files.
+type RootFS struct {
+	*vfs.PathFS
+	root string
+}
+
+// NewRootFS returns a RootFS for the system mounted at root
+func NewRootFS(root string) *RootFS {
+	return &RootFS{PathFS: vfs.NewPathFS(vfs.OSFS, root), root: root}
+}
+
+// Root returns the path the system is mounted at
+func (r *RootFS) Root() string {
+	return r.root
+}
+
+// OfflineRoot returns the path of the offline system fs applies to, if it is a RootFS
+func OfflineRoot(fs vfs.FS) (string, bool) {
+	r, ok := fs.(*RootFS)
+	if !ok {
+		return "", false
+	}
+	return r.Root(), true
+}
+
+// skipOffline logs that the host-only action is skipped, and returns true, if fs
+// applies to an offline system
+func skipOffline(l logger.Interface, fs vfs.FS, action string) bool {
+	root, ok := OfflineRoot(fs)
+	if ok {
+		l.Infof("Skipping %s: host-only action, not applied to the offline root %s", action, root)
+	}
+	return ok
+}
+
+// userExists returns true if the user exists in the system fs applies to
+func userExists(fs vfs.FS, u schema.User) bool {
+	if _, ok := OfflineRoot(fs); !ok {
+		return u.Exists()
+	}
+	path, err := fs.RawPath("/etc/passwd")
+	if err != nil {
+		return false
+	}
+	users, err := passwd.ParseFile(path)
+	if err != nil {
+		return false
+	}
+	_, ok := users[u.Name]
+	return ok
+}
+
+// lookupGid returns the gid of the group in the system fs applies to
+func lookupGid(fs vfs.FS, group string) (int, error) {
+	path, err := fs.RawPath("/etc/group")
+	if err != nil {
+		return 0, errors.Wrap(err, "getting rawpath for /etc/group")
+	}
+	groups, err := entities.ParseGroup(path)
+	if err != nil {
+		return 0, err
+	}
+	g, ok := groups[group]
+	if !ok || g.Gid == nil {
+		return 0, errors.Errorf("group: unknown group %s", group)
+	}
+	return *g.Gid, nil
+}
+
+// ownerIDs returns the uid and gid of an owner in the "user:group" or "user" syntax,
+// from the system fs applies to
+func ownerIDs(fs vfs.FS, owner string) (int, int, error) {
+	if _, ok := OfflineRoot(fs); !ok {
+		return utils.GetUserDataFromString(owner)
+	}
+
+	name, group, hasGroup := owner, "", false
+	if i := strings.Index(owner, ":"); i >= 0 {
+		name, group, hasGroup = owner[:i], owner[i+1:], true
+	}
+
+	path, err := fs.RawPath("/etc/passwd")
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "getting rawpath for /etc/passwd")
+	}
+	users, err := passwd.ParseFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	u, ok := users[name]
+	if !ok {
+		return 0, 0, errors.Errorf("while looking up user %s: unknown user", name)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed converting uid to int")
+	}
+	if hasGroup {
+		gid, err := lookupGid(fs, group)
+		if err != nil {
+			return 0, 0, errors.Wrapf(err, "while looking up group %s", group)
+		}
+		return uid, gid, nil
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "failed converting gid to int")
+	}
+	return uid, gid, nil
+}
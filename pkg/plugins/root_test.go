@@ -0,0 +1,152 @@
+package plugins_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	. "github.com/bhojpur/deploy/pkg/plugins"
+	"github.com/bhojpur/deploy/pkg/schema"
+	consoletests "github.com/bhojpur/deploy/tests/console"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("RootFS", func() {
+	var root string
+	var fs *RootFS
+	testConsole := consoletests.TestConsole{}
+
+	BeforeEach(func() {
+		consoletests.Reset()
+		var err error
+		root, err = ioutil.TempDir("", "root")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(os.MkdirAll(filepath.Join(root, "etc"), 0755)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(root, "etc/passwd"), []byte("root:x:0:0:root:/root:/bin/sh\nfoo:x:1100:1100::/home/foo:/bin/sh\n"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(root, "etc/group"), []byte("root:x:0:\nfoo:x:1100:\nwheel:x:1200:\n"), 0644)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(root, "etc/shadow"), []byte("root:x:::::::\n"), 0600)).To(Succeed())
+		Expect(ioutil.WriteFile(filepath.Join(root, "etc/hosts"), []byte("127.0.0.1 localhost\n"), 0644)).To(Succeed())
+		fs = NewRootFS(root)
+	})
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	It("is an offline root", func() {
+		r, ok := OfflineRoot(fs)
+		Expect(ok).To(BeTrue())
+		Expect(r).To(Equal(root))
+	})
+
+	It("sets the hostname in the root only", func() {
+		host, err := os.Hostname()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		err = Hostname(logrus.New(), schema.Stage{Hostname: "offline-" + host}, fs, testConsole)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		b, err := ioutil.ReadFile(filepath.Join(root, "etc/hostname"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(b)).To(Equal("offline-" + host + "\n"))
+		Expect(os.Hostname()).To(Equal(host))
+	})
+
+	It("skips the host-only actions", func() {
+		err := Sysctl(logrus.New(), schema.Stage{Sysctl: map[string]string{"vm.swappiness": "10"}}, fs, testConsole)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(filepath.Join(root, "proc")).ToNot(BeADirectory())
+
+		err = Systemctl(logrus.New(), schema.Stage{
+			Systemctl: schema.Systemctl{Enable: []string{"foo"}, Start: []string{"bar"}},
+		}, fs, testConsole)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(consoletests.Commands).To(Equal([]string{"systemctl enable foo"}))
+
+		changes, err := PlanSystemctl(logrus.New(), schema.Stage{
+			Systemctl: schema.Systemctl{Enable: []string{"foo"}, Start: []string{"bar"}},
+		}, fs, testConsole)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(changes).To(HaveLen(1))
+		Expect(changes[0].Target).To(Equal("systemctl enable foo"))
+	})
+
+	It("creates users from the files of the root", func() {
+		err := User(logrus.New(), schema.Stage{
+			Users: map[string]schema.User{
+				"root": {PasswordHash: "$6$foo"},
+				"bar":  {PrimaryGroup: "wheel", Homedir: "/home/bar"},
+			},
+		}, fs, testConsole)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		b, err := ioutil.ReadFile(filepath.Join(root, "etc/passwd"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(b)).To(MatchRegexp(`(?m)^bar:x:1101:1200:[^:]*:/home/bar:/bin/sh$`))
+
+		b, err = ioutil.ReadFile(filepath.Join(root, "etc/shadow"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(b)).To(ContainSubstring("root:$6$foo:"))
+
+		info, err := os.Stat(filepath.Join(root, "home/bar"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(info.IsDir()).To(BeTrue())
+		if os.Getuid() == 0 {
+			Expect(info.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(1101)))
+		}
+	})
+
+	It("resolves the owners of the files from the root", func() {
+		if os.Getuid() != 0 {
+			Skip("changing the owner of files needs root")
+		}
+		err := EnsureFiles(logrus.New(), schema.Stage{
+			Files: []schema.File{{Path: "/etc/foo.conf", Content: "foo", Permissions: 0640, OwnerString: "foo:wheel"}},
+		}, fs, testConsole)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		info, err := os.Stat(filepath.Join(root, "etc/foo.conf"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(info.Sys().(*syscall.Stat_t).Uid).To(Equal(uint32(1100)))
+		Expect(info.Sys().(*syscall.Stat_t).Gid).To(Equal(uint32(1200)))
+	})
+
+	It("applies the entities to the files of the root", func() {
+		err := Entities(logrus.New(), schema.Stage{
+			EnsureEntities: []schema.BhojpurEntity{{Entity: `kind: "group"
+group_name: "baz"
+password: "x"
+gid: 1300
+users: "foo"
+`}},
+		}, fs, testConsole)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		b, err := ioutil.ReadFile(filepath.Join(root, "etc/group"))
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(string(b)).To(ContainSubstring("baz:x:1300:foo"))
+	})
+})
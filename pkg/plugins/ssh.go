@@ -25,6 +25,7 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -184,3 +185,30 @@ func ensureKeys(user string, keys []string, fs vfs.FS) error {
 	}
 	return errs
 }
+
+// PlanSSH returns the authorized_keys files SSH would update
+func PlanSSH(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	for u, keys := range s.SSHKeys {
+		if len(keys) == 0 {
+			continue
+		}
+		target := path.Join("~"+u, sshDir, authorizedFile)
+		if f, err := fs.RawPath(passwdFile); err == nil {
+			if current, err := passwd.ParseFile(f); err == nil {
+				if data, ok := current[u]; ok {
+					target = path.Join(data.Home, sshDir, authorizedFile)
+				}
+			}
+		}
+		changes = append(changes, Change{
+			Plugin: "authorized_keys",
+			Kind:   KindFile,
+			Action: ActionUpdate,
+			Target: target,
+			Detail: fmt.Sprintf("%d key(s) for user %s", len(keys), u),
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Target < changes[j].Target })
+	return changes, nil
+}
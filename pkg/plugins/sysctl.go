@@ -22,6 +22,7 @@ package plugins
 
 import (
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/bhojpur/deploy/pkg/logger"
@@ -35,6 +36,10 @@ var (
 )
 
 func Sysctl(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error {
+	if len(s.Sysctl) == 0 || skipOffline(l, fs, "setting kernel parameters") {
+		return nil
+	}
+
 	var errs error
 	for k, v := range s.Sysctl {
 		elements := procSys
@@ -46,3 +51,25 @@ func Sysctl(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) erro
 	}
 	return errs
 }
+
+// PlanSysctl returns the kernel parameters Sysctl would write
+func PlanSysctl(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if _, ok := OfflineRoot(fs); ok {
+		return nil, nil
+	}
+
+	var changes []Change
+	for k, v := range s.Sysctl {
+		elements := procSys
+		elements = append(elements, strings.Split(k, ".")...)
+		changes = append(changes, Change{
+			Plugin: "sysctl",
+			Kind:   KindFile,
+			Action: ActionUpdate,
+			Target: filepath.Join(elements...),
+			Detail: k + "=" + v,
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Target < changes[j].Target })
+	return changes, nil
+}
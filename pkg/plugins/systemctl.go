@@ -21,6 +21,8 @@ package plugins
 // THE SOFTWARE.
 
 import (
+	"fmt"
+
 	"github.com/bhojpur/deploy/pkg/logger"
 	"github.com/bhojpur/deploy/pkg/schema"
 	"github.com/hashicorp/go-multierror"
@@ -39,8 +41,35 @@ func Systemctl(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) e
 	if err := console.RunTemplate(s.Systemctl.Mask, "systemctl mask %s"); err != nil {
 		errs = multierror.Append(errs, err)
 	}
-	if err := console.RunTemplate(s.Systemctl.Start, "systemctl start %s"); err != nil {
-		errs = multierror.Append(errs, err)
+	// Units are only started on the running system, an offline one starts them at boot
+	if len(s.Systemctl.Start) > 0 && !skipOffline(l, fs, "starting units") {
+		if err := console.RunTemplate(s.Systemctl.Start, "systemctl start %s"); err != nil {
+			errs = multierror.Append(errs, err)
+		}
 	}
 	return errs
 }
+
+// PlanSystemctl returns the systemctl commands Systemctl would run
+func PlanSystemctl(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	start := s.Systemctl.Start
+	if _, ok := OfflineRoot(fs); ok {
+		start = nil
+	}
+
+	var changes []Change
+	for _, op := range []struct {
+		action string
+		units  []string
+	}{
+		{"enable", s.Systemctl.Enable},
+		{"disable", s.Systemctl.Disable},
+		{"mask", s.Systemctl.Mask},
+		{"start", start},
+	} {
+		for _, u := range op.units {
+			changes = append(changes, commandChange("systemctl", fmt.Sprintf("systemctl %s %s", op.action, u)))
+		}
+	}
+	return changes, nil
+}
@@ -22,6 +22,7 @@ package plugins
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/bhojpur/deploy/pkg/logger"
@@ -45,3 +46,13 @@ func SystemdFirstboot(l logger.Interface, s schema.Stage, fs vfs.FS, console Con
 
 	return errs
 }
+
+// PlanSystemdFirstboot returns the systemd-firstboot commands SystemdFirstboot would run
+func PlanSystemdFirstboot(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	for k, v := range s.SystemdFirstBoot {
+		changes = append(changes, commandChange("systemd_firstboot", fmt.Sprintf("systemd-firstboot --%s=%s", strings.ToLower(k), v)))
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Target < changes[j].Target })
+	return changes, nil
+}
@@ -22,6 +22,8 @@ package plugins
 
 import (
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/bhojpur/deploy/pkg/logger"
 	"github.com/bhojpur/deploy/pkg/schema"
@@ -60,3 +62,28 @@ func Timesyncd(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) e
 
 	return errs
 }
+
+// PlanTimesyncd returns the timesyncd settings Timesyncd would write
+func PlanTimesyncd(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	if len(s.TimeSyncd) == 0 {
+		return nil, nil
+	}
+
+	action := ActionUpdate
+	if _, err := fs.Stat(timeSyncd); os.IsNotExist(err) {
+		action = ActionCreate
+	}
+
+	keys := []string{}
+	for k, v := range s.TimeSyncd {
+		keys = append(keys, k+"="+v)
+	}
+	sort.Strings(keys)
+	return []Change{{
+		Plugin: "timesyncd",
+		Kind:   KindFile,
+		Action: action,
+		Target: timeSyncd,
+		Detail: "[Time] " + strings.Join(keys, " "),
+	}}, nil
+}
@@ -87,11 +87,10 @@ func createUser(fs vfs.FS, u schema.User, console Console) error {
 	gid := 1000
 
 	if u.PrimaryGroup != "" {
-		gr, err := osuser.LookupGroup(u.PrimaryGroup)
+		gid, err = primaryGid(fs, u.PrimaryGroup)
 		if err != nil {
 			return errors.Wrap(err, "could not resolve primary group of user")
 		}
-		gid, _ = strconv.Atoi(gr.Gid)
 		primaryGroup = u.PrimaryGroup
 	} else {
 		// Create a new group after the user name
@@ -171,12 +170,8 @@ func createUser(fs vfs.FS, u schema.User, console Console) error {
 	}
 
 	if !u.NoCreateHome {
-		homedir, err := fs.RawPath(u.Homedir)
-		if err != nil {
-			return errors.Wrap(err, "getting rawpath for homedir")
-		}
-		os.MkdirAll(homedir, 0755)
-		os.Chown(homedir, uid, gid)
+		vfs.MkdirAll(fs, u.Homedir, 0755)
+		fs.Chown(u.Homedir, uid, gid)
 	}
 
 	groups, _ := entities.ParseGroup(etcgroup)
@@ -192,6 +187,18 @@ func createUser(fs vfs.FS, u schema.User, console Console) error {
 	return nil
 }
 
+// primaryGid returns the gid of the primary group of a user
+func primaryGid(fs vfs.FS, group string) (int, error) {
+	if _, ok := OfflineRoot(fs); ok {
+		return lookupGid(fs, group)
+	}
+	gr, err := osuser.LookupGroup(group)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(gr.Gid)
+}
+
 func setUserPass(fs vfs.FS, username, password string) error {
 	etcshadow, err := fs.RawPath("/etc/shadow")
 	if err != nil {
@@ -214,7 +221,7 @@ func User(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error
 	for u, p := range s.Users {
 		r := &p
 		r.Name = u
-		if !p.Exists() {
+		if !userExists(fs, p) {
 			if err := createUser(fs, *r, console); err != nil {
 				errs = multierror.Append(errs, err)
 			}
@@ -231,3 +238,40 @@ func User(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) error
 	}
 	return errs
 }
+
+// PlanUser returns the users User would create or update, and the files it writes them to
+func PlanUser(l logger.Interface, s schema.Stage, fs vfs.FS, console Console) ([]Change, error) {
+	var changes []Change
+	names := []string{}
+	for u := range s.Users {
+		names = append(names, u)
+	}
+	sort.Strings(names)
+
+	written := map[string]bool{}
+	for _, u := range names {
+		p := s.Users[u]
+		p.Name = u
+		switch {
+		case !userExists(fs, p):
+			changes = append(changes, Change{Plugin: "users", Kind: KindUser, Action: ActionCreate, Target: u})
+			written["/etc/group"], written["/etc/passwd"], written["/etc/shadow"] = true, true, true
+		case p.PasswordHash != "":
+			changes = append(changes, Change{Plugin: "users", Kind: KindUser, Action: ActionUpdate, Target: u, Detail: "password"})
+			written["/etc/shadow"] = true
+		}
+		if len(p.SSHAuthorizedKeys) > 0 {
+			keys, err := PlanSSH(l, schema.Stage{SSHKeys: map[string][]string{u: p.SSHAuthorizedKeys}}, fs, console)
+			if err != nil {
+				return changes, err
+			}
+			changes = append(changes, keys...)
+		}
+	}
+	for _, f := range []string{"/etc/group", "/etc/passwd", "/etc/shadow"} {
+		if written[f] {
+			changes = append(changes, Change{Plugin: "users", Kind: KindFile, Action: ActionUpdate, Target: f})
+		}
+	}
+	return changes, nil
+}
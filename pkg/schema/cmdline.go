@@ -0,0 +1,88 @@
+package schema
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/shlex"
+	"github.com/twpayne/go-vfs"
+)
+
+const (
+	// CmdlineScheme is the scheme of the uris loading configs from the kernel command
+	// line, e.g. cmdline:// or cmdline://<prefix>
+	CmdlineScheme = "cmdline://"
+
+	// DefaultCmdlinePrefix is the prefix of the kernel parameters loaded by default
+	DefaultCmdlinePrefix = "deploy."
+
+	// cmdlineConfigURL is the kernel parameter including another config
+	cmdlineConfigURL = "config_url"
+)
+
+// IsCmdline tells if s is an uri of the kernel command line
+func IsCmdline(s string) bool {
+	return strings.HasPrefix(s, CmdlineScheme)
+}
+
+// FromCmdline loads a Bhojpur Deploy config from the kernel parameters, read from /proc/cmdline.
+// Only the parameters with the prefix given in the uri s are kept, DefaultCmdlinePrefix if none,
+// and they are read in dot notation once the prefix is removed, e.g.
+// deploy.stages.boot[0].commands[0]="echo hi". The modifier is not applied.
+//
+// <prefix>config_url=<url> parameters include the config at the url, or absolute path.
+func FromCmdline(s string, fs vfs.FS, m Modifier) ([]byte, error) {
+	prefix := strings.TrimPrefix(s, CmdlineScheme)
+	if prefix == "" {
+		prefix = DefaultCmdlinePrefix
+	}
+
+	data, err := fs.ReadFile("/proc/cmdline")
+	if err != nil {
+		return nil, err
+	}
+	params, err := shlex.Split(string(data))
+	if err != nil {
+		return nil, err
+	}
+
+	v := map[string]interface{}{}
+	includes := 0
+	for _, p := range params {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(p, prefix), "=", 2)
+		key := parts[0]
+		value := "true"
+		if len(parts) > 1 {
+			value = parts[1]
+		}
+		if key == cmdlineConfigURL {
+			key = fmt.Sprintf("include[%d]", includes)
+			includes++
+		}
+		v[key] = value
+	}
+	return dotToYAML(v)
+}
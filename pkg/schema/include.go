@@ -0,0 +1,175 @@
+package schema
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/bhojpur/deploy/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/twpayne/go-vfs"
+)
+
+// includer loads configs along with the configs they include
+type includer struct {
+	fs   vfs.FS
+	m    Modifier
+	seen Loaded
+}
+
+// Loaded is the set of the configs loaded, with the configs they include, by absolute
+// path or url. A config is only loaded the first time, see LoadOnce.
+type Loaded map[string]bool
+
+// loadedKey returns the key of the config at uri in Loaded
+func loadedKey(uri string) string {
+	if IsCmdline(uri) || utils.IsUrl(uri) {
+		return uri
+	}
+	if abs, err := filepath.Abs(uri); err == nil {
+		return abs
+	}
+	return filepath.Clean(uri)
+}
+
+// load loads the config at uri and merges the stages of the configs it includes, in the
+// order they are listed, before its own. chain is the list of configs including it.
+func (in *includer) load(uri string, l Loader, chain []string) (*BhojpurConfig, error) {
+	config, err := loadConfig(uri, in.fs, l, in.m)
+	if err != nil || len(config.Include) == 0 {
+		return config, err
+	}
+
+	// Inline configs include paths relative to the working directory
+	base, from := "", "inline config"
+	if len(chain) > 0 {
+		base, from = uri, uri
+	}
+
+	stages := map[string][]Stage{}
+	for _, inc := range config.Include {
+		target, loader, err := resolveInclude(base, inc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "while including %s from %s", inc, from)
+		}
+		// The configs of the chain can be written in other forms, e.g. relative
+		for _, c := range chain {
+			if loadedKey(c) == loadedKey(target) {
+				return nil, errors.Errorf("include cycle: %s", strings.Join(append(chain, target), " -> "))
+			}
+		}
+		// A config included several times, or also loaded by itself, is merged only the first time
+		if in.seen[loadedKey(target)] {
+			continue
+		}
+		in.seen[loadedKey(target)] = true
+
+		included, err := in.load(target, loader, append(chain[:len(chain):len(chain)], target))
+		if err != nil {
+			return nil, errors.Wrapf(err, "while including %s from %s", target, from)
+		}
+		if err := mergePlugins(config, included); err != nil {
+			return nil, errors.Wrapf(err, "while including %s from %s", target, from)
+		}
+		for name, steps := range included.Stages {
+			for _, st := range steps {
+				stages[name] = append(stages[name], withVars(st, included.Vars))
+			}
+		}
+	}
+	for name, steps := range config.Stages {
+		stages[name] = append(stages[name], steps...)
+	}
+	config.Stages = stages
+	return config, nil
+}
+
+// mergePlugins gives the config the plugins allow-list of the config it includes. Configs
+// allowing different plugins can't include each other.
+func mergePlugins(config, included *BhojpurConfig) error {
+	if len(included.Plugins) == 0 {
+		return nil
+	}
+	if len(config.Plugins) == 0 {
+		config.Plugins = included.Plugins
+		return nil
+	}
+	allowed := map[string]bool{}
+	for _, p := range config.Plugins {
+		allowed[p] = true
+	}
+	conflict := len(config.Plugins) != len(included.Plugins)
+	for _, p := range included.Plugins {
+		conflict = conflict || !allowed[p]
+	}
+	if conflict {
+		return errors.Errorf("the allowed plugins %s conflict with %s",
+			strings.Join(included.Plugins, ", "), strings.Join(config.Plugins, ", "))
+	}
+	return nil
+}
+
+// withVars returns the step with the variables of the config it was included from, its
+// own overriding them
+func withVars(s Stage, vars map[string]string) Stage {
+	if len(vars) == 0 {
+		return s
+	}
+	merged := make(map[string]string, len(vars)+len(s.Vars))
+	for k, v := range vars {
+		merged[k] = v
+	}
+	for k, v := range s.Vars {
+		merged[k] = v
+	}
+	s.Vars = merged
+	return s
+}
+
+// resolveInclude returns where an include points to, relative to the config including it,
+// and the loader for it
+func resolveInclude(base, include string) (string, Loader, error) {
+	switch {
+	case IsCmdline(include):
+		return include, FromCmdline, nil
+	case utils.IsUrl(include):
+		return include, FromUrl, nil
+	case IsCmdline(base):
+		// Relative includes of the kernel command line are relative to the root
+		return filepath.Join("/", include), FromFile, nil
+	case utils.IsUrl(base):
+		u, err := url.Parse(base)
+		if err != nil {
+			return "", nil, err
+		}
+		ref, err := url.Parse(include)
+		if err != nil {
+			return "", nil, err
+		}
+		return u.ResolveReference(ref).String(), FromUrl, nil
+	case base == "" || filepath.IsAbs(include):
+		return filepath.Clean(include), FromFile, nil
+	default:
+		return filepath.Join(filepath.Dir(base), include), FromFile, nil
+	}
+}
@@ -0,0 +1,163 @@
+package schema
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JSONSchemaDraft is the version of JSON Schema the schema of the configs follows
+const JSONSchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// JSONSchema is a JSON Schema, with the keywords the schema of the configs uses
+type JSONSchema struct {
+	Schema      string   `json:"$schema,omitempty"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Type        []string `json:"-"`
+
+	Properties map[string]*JSONSchema `json:"properties,omitempty"`
+	// AdditionalProperties is the schema of the keys of an object which are not among
+	// its Properties. Objects with no AdditionalProperties have no other keys.
+	AdditionalProperties *JSONSchema   `json:"-"`
+	Items                *JSONSchema   `json:"items,omitempty"`
+	Enum                 []string      `json:"enum,omitempty"`
+	Minimum              *float64      `json:"minimum,omitempty"`
+	OneOf                []*JSONSchema `json:"oneOf,omitempty"`
+}
+
+// MarshalJSON writes a single type as a string, and closes the objects without
+// AdditionalProperties
+func (s JSONSchema) MarshalJSON() ([]byte, error) {
+	type plain JSONSchema
+	out := struct {
+		plain
+		Type                 interface{} `json:"type,omitempty"`
+		AdditionalProperties interface{} `json:"additionalProperties,omitempty"`
+	}{plain: plain(s)}
+
+	switch len(s.Type) {
+	case 0:
+	case 1:
+		out.Type = s.Type[0]
+	default:
+		out.Type = s.Type
+	}
+	switch {
+	case s.AdditionalProperties != nil:
+		out.AdditionalProperties = s.AdditionalProperties
+	case s.is("object"):
+		out.AdditionalProperties = false
+	}
+	return json.Marshal(out)
+}
+
+// is tells if t is among the types of the schema
+func (s *JSONSchema) is(t string) bool {
+	for _, st := range s.Type {
+		if st == t {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateJSONSchema returns the JSON Schema of the YAML configs, generated from BhojpurConfig
+func GenerateJSONSchema() *JSONSchema {
+	s := typeSchema(reflect.TypeOf(BhojpurConfig{}))
+	s.Schema = JSONSchemaDraft
+	s.Title = "Bhojpur Deploy config"
+	return s
+}
+
+var (
+	durationType = reflect.TypeOf(Duration(0))
+	envVarsType  = reflect.TypeOf(EnvVars{})
+)
+
+// typeSchema returns the schema of the values yaml.v2 decodes into t
+func typeSchema(t reflect.Type) *JSONSchema {
+	switch t {
+	case durationType:
+		return &JSONSchema{Type: []string{"integer", "string"}, Description: "Duration, as a number of seconds or a duration string, e.g. 1m30s"}
+	case envVarsType:
+		return &JSONSchema{Type: []string{"object"}, AdditionalProperties: &JSONSchema{OneOf: []*JSONSchema{
+			{Type: []string{"string"}},
+			typeSchema(reflect.TypeOf(envVar{})),
+		}}}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem())
+	case reflect.String:
+		return &JSONSchema{Type: []string{"string"}}
+	case reflect.Bool:
+		return &JSONSchema{Type: []string{"boolean"}}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &JSONSchema{Type: []string{"integer"}}
+	case reflect.Float32, reflect.Float64:
+		return &JSONSchema{Type: []string{"number"}}
+	case reflect.Slice, reflect.Array:
+		return &JSONSchema{Type: []string{"array"}, Items: typeSchema(t.Elem())}
+	case reflect.Map:
+		return &JSONSchema{Type: []string{"object"}, AdditionalProperties: typeSchema(t.Elem())}
+	case reflect.Struct:
+		s := &JSONSchema{Type: []string{"object"}, Properties: map[string]*JSONSchema{}}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, ok := yamlKey(f)
+			if !ok {
+				continue
+			}
+			fs := typeSchema(f.Type)
+			if enum := f.Tag.Get("enum"); enum != "" {
+				fs.Enum = strings.Split(enum, ",")
+			}
+			if min, err := strconv.ParseFloat(f.Tag.Get("minimum"), 64); err == nil {
+				fs.Minimum = &min
+			}
+			s.Properties[name] = fs
+		}
+		return s
+	}
+	return &JSONSchema{}
+}
+
+// yamlKey returns the key of the field in YAML, as yaml.v2 names it, and false
+// if the field is not decoded
+func yamlKey(f reflect.StructField) (string, bool) {
+	if f.PkgPath != "" {
+		return "", false
+	}
+	tag := f.Tag.Get("yaml")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return strings.ToLower(f.Name), true
+}
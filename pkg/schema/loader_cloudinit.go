@@ -124,6 +124,9 @@ func (cloudInit) Load(s []byte, fs vfs.FS) (*BhojpurConfig, error) {
 		for k, v := range bhojpurConfig.Stages {
 			result.Stages[k] = append(result.Stages[k], v...)
 		}
+		result.Plugins = bhojpurConfig.Plugins
+		result.Include = bhojpurConfig.Include
+		result.Vars = bhojpurConfig.Vars
 	}
 
 	return result, nil
@@ -49,8 +49,10 @@ type File struct {
 	Permissions  uint32
 	Owner, Group int
 	Content      string
-	Encoding     string
+	Encoding     string `enum:"b64,base64,gz,gzip,gz+base64,gzip+base64,gz+b64,gzip+b64"`
 	OwnerString  string
+	// Secret masks the content of the file in logs, events and reports
+	Secret bool
 }
 
 type Download struct {
@@ -83,8 +85,8 @@ type Git struct {
 
 type Auth struct {
 	Username   string `yaml:"username,omitempty"`
-	Password   string `yaml:"password,omitempty"`
-	PrivateKey string `yaml:"private_key,omitempty"`
+	Password   string `yaml:"password,omitempty" secret:"true"`
+	PrivateKey string `yaml:"private_key,omitempty" secret:"true"`
 
 	Insecure  bool   `yaml:"insecure,omitempty"`
 	PublicKey string `yaml:"public_key,omitempty"`
@@ -92,7 +94,7 @@ type Auth struct {
 
 type User struct {
 	Name              string   `yaml:"name,omitempty"`
-	PasswordHash      string   `yaml:"passwd,omitempty"`
+	PasswordHash      string   `yaml:"passwd,omitempty" secret:"true"`
 	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
 	GECOS             string   `yaml:"gecos,omitempty"`
 	Homedir           string   `yaml:"homedir,omitempty"`
@@ -107,6 +109,7 @@ type User struct {
 	UID               string   `yaml:"uid,omitempty"`
 }
 
+// Exists returns true if the user exists on the running system
 func (u User) Exists() bool {
 	_, err := user.Lookup(u.Name)
 	return err == nil
@@ -131,7 +134,7 @@ type Partition struct {
 	FSLabel    string `yaml:"fsLabel,omitempty"`
 	Size       uint   `yaml:"size,omitempty"`
 	PLabel     string `yaml:"pLabel,omitempty"`
-	FileSystem string `yaml:"filesystem,omitempty"`
+	FileSystem string `yaml:"filesystem,omitempty" enum:"ext2,ext3,ext4,xfs,vfat,fat"`
 }
 
 type Stage struct {
@@ -140,21 +143,43 @@ type Stage struct {
 	Downloads   []Download  `yaml:"downloads,omitempty"`
 	Directories []Directory `yaml:"directories,omitempty"`
 	If          string      `yaml:"if,omitempty"`
+	OnlyIf      string      `yaml:"only_if,omitempty"`
+	Unless      string      `yaml:"unless,omitempty"`
 
 	EnsureEntities  []BhojpurEntity     `yaml:"ensure_entities,omitempty"`
 	DeleteEntities  []BhojpurEntity     `yaml:"delete_entities,omitempty"`
 	Dns             DNS                 `yaml:"dns,omitempty"`
 	Hostname        string              `yaml:"hostname,omitempty"`
 	Name            string              `yaml:"name,omitempty"`
+	ID              string              `yaml:"id,omitempty"`
+	DependsOn       []string            `yaml:"depends_on,omitempty"`
+	Timeout         Duration            `yaml:"timeout,omitempty"`
+	Retries         int                 `yaml:"retries,omitempty" minimum:"0"`
+	RetryDelay      Duration            `yaml:"retry_delay,omitempty" minimum:"0"`
+	RetryBackoff    float64             `yaml:"retry_backoff,omitempty" minimum:"0"`
+	OnFailure       string              `yaml:"on_failure,omitempty" enum:"continue,skip_remaining,abort"`
+	Once            bool                `yaml:"once,omitempty"`
+	Run             string              `yaml:"run,omitempty" enum:"always,on_change"`
+	Transactional   bool                `yaml:"transactional,omitempty"`
+	Vars            map[string]string   `yaml:"vars,omitempty"`
 	Sysctl          map[string]string   `yaml:"sysctl,omitempty"`
 	SSHKeys         map[string][]string `yaml:"authorized_keys,omitempty"`
 	Node            string              `yaml:"node,omitempty"`
+	Match           map[string]string   `yaml:"match,omitempty"`
 	Users           map[string]User     `yaml:"users,omitempty"`
 	Modules         []string            `yaml:"modules,omitempty"`
 	Systemctl       Systemctl           `yaml:"systemctl,omitempty"`
-	Environment     map[string]string   `yaml:"environment,omitempty"`
+	Environment     EnvVars             `yaml:"environment,omitempty"`
 	EnvironmentFile string              `yaml:"environment_file,omitempty"`
 
+	// SecretEnvironment lists the environment variables masked in logs, events and reports,
+	// the ones given as secret in the environment of the step
+	SecretEnvironment []string `yaml:"-" json:"-"`
+
+	// TemplateData is the data the templates of the step are rendered with, set by the
+	// executor once per run. Without it, the plugins generate their own.
+	TemplateData map[string]interface{} `yaml:"-" json:"-"`
+
 	DataSources DataSource `yaml:"datasource,omitempty"`
 	Layout      Layout     `yaml:"layout,omitempty"`
 
@@ -164,6 +189,24 @@ type Stage struct {
 	Git       Git               `yaml:"git,omitempty"`
 }
 
+// Policies for the on_failure field of a Stage
+const (
+	// OnFailureContinue runs the following steps anyway
+	OnFailureContinue = "continue"
+	// OnFailureSkipRemaining skips the remaining steps of the stage
+	OnFailureSkipRemaining = "skip_remaining"
+	// OnFailureAbort stops the whole run, interrupting the steps still running
+	OnFailureAbort = "abort"
+)
+
+// Policies for the run field of a Stage
+const (
+	// RunAlways runs the step every time its stage runs
+	RunAlways = "always"
+	// RunOnChange runs the step only if its definition changed since it last succeeded
+	RunOnChange = "on_change"
+)
+
 type Systemctl struct {
 	Enable  []string `yaml:"enable,omitempty"`
 	Disable []string `yaml:"disable,omitempty"`
@@ -181,6 +224,15 @@ type DNS struct {
 type BhojpurConfig struct {
 	Name   string             `yaml:"name,omitempty"`
 	Stages map[string][]Stage `yaml:"stages,omitempty"`
+
+	// Plugins restricts the steps of the config to the plugins listed, by name
+	Plugins []string `yaml:"plugins,omitempty"`
+
+	// Include lists the configs whose stages are merged before the ones of the config
+	Include []string `yaml:"include,omitempty"`
+
+	// Vars are the variables the templates in the steps of the config are rendered with
+	Vars map[string]string `yaml:"vars,omitempty"`
 }
 
 type Loader func(s string, fs vfs.FS, m Modifier) ([]byte, error)
@@ -190,13 +242,36 @@ type bhojpurLoader interface {
 	Load([]byte, vfs.FS) (*BhojpurConfig, error)
 }
 
+// Load loads a Bhojpur Deploy config with l, along with the configs it includes.
+// A nil Loader loads s as the config itself.
 func Load(s string, fs vfs.FS, l Loader, m Modifier) (*BhojpurConfig, error) {
+	return LoadOnce(s, fs, l, m, Loaded{})
+}
+
+// LoadOnce is Load, skipping the configs in loaded, which it adds s and the configs it
+// includes to. It returns a nil config if s itself was loaded already.
+func LoadOnce(s string, fs vfs.FS, l Loader, m Modifier, loaded Loaded) (*BhojpurConfig, error) {
 	if m == nil {
 		m = func(b []byte) ([]byte, error) { return b, nil }
 	}
+	in := &includer{fs: fs, m: m, seen: loaded}
 	if l == nil {
 		l = func(c string, fs vfs.FS, m Modifier) ([]byte, error) { return m([]byte(c)) }
+		return in.load(s, l, nil)
+	}
+	if loaded[loadedKey(s)] {
+		return nil, nil
 	}
+	loaded[loadedKey(s)] = true
+	return in.load(s, l, []string{s})
+}
+
+// Parse parses the config s, without loading the configs it includes
+func Parse(s string) (*BhojpurConfig, error) {
+	return loadConfig(s, nil, func(c string, fs vfs.FS, m Modifier) ([]byte, error) { return []byte(c), nil }, nil)
+}
+
+func loadConfig(s string, fs vfs.FS, l Loader, m Modifier) (*BhojpurConfig, error) {
 	data, err := l(s, fs, m)
 	if err != nil {
 		return nil, errors.Wrap(err, "while loading Bhojpur Deploy config")
@@ -21,6 +21,10 @@ package schema_test
 // THE SOFTWARE.
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
 	. "github.com/bhojpur/deploy/pkg/schema"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -88,6 +92,264 @@ var _ = Describe("Schema", func() {
 		})
 	})
 
+	Context("Loading step timeouts", func() {
+		It("Reads durations and seconds", func() {
+			bhojpurConfig := loadstdBhojpur(`
+stages:
+  test:
+  - timeout: 1m30s
+  - timeout: 10
+  - name: "no timeout"
+`)
+			Expect(bhojpurConfig.Stages["test"][0].Timeout.Duration()).To(Equal(90 * time.Second))
+			Expect(bhojpurConfig.Stages["test"][1].Timeout.Duration()).To(Equal(10 * time.Second))
+			Expect(bhojpurConfig.Stages["test"][2].Timeout.Duration()).To(Equal(time.Duration(0)))
+		})
+
+		It("Fails on invalid durations", func() {
+			_, err := Load("stages:\n  test:\n  - timeout: soon\n", nil, nil, nil)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("Loading secrets", func() {
+		It("Reads secret environment variables and masks the secrets of a step", func() {
+			bhojpurConfig := loadstdBhojpur(`
+stages:
+  test:
+  - environment:
+      USER: admin
+      TOKEN:
+        value: s3cr3t-token
+        secret: true
+    files:
+    - path: /etc/app.key
+      content: private-key
+      secret: true
+    - path: /etc/app.conf
+      content: public
+    users:
+      bar:
+        passwd: "$6$hash"
+`)
+			step := bhojpurConfig.Stages["test"][0]
+			Expect(step.Environment).To(Equal(EnvVars{"USER": "admin", "TOKEN": "s3cr3t-token"}))
+			Expect(step.SecretEnvironment).To(Equal([]string{"TOKEN"}))
+			Expect(step.Secrets()).To(ConsistOf("$6$hash", "private-key", "s3cr3t-token"))
+
+			redacted := step.Redacted()
+			Expect(redacted.Environment).To(Equal(EnvVars{"USER": "admin", "TOKEN": "******"}))
+			Expect(redacted.Files[0].Content).To(Equal("******"))
+			Expect(redacted.Files[1].Content).To(Equal("public"))
+			Expect(redacted.Users["bar"].PasswordHash).To(Equal("******"))
+			Expect(step.Environment["TOKEN"]).To(Equal("s3cr3t-token"))
+			Expect(step.Users["bar"].PasswordHash).To(Equal("$6$hash"))
+		})
+
+		It("Takes the secret environment variables only from the environment", func() {
+			bhojpurConfig := loadstdBhojpur(`
+stages:
+  test:
+  - environment:
+      USER: admin
+    secret_environment:
+    - USER
+`)
+			step := bhojpurConfig.Stages["test"][0]
+			Expect(step.SecretEnvironment).To(BeEmpty())
+
+			errs, err := Validate([]byte("stages:\n  test:\n  - secret_environment: [USER]\n"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(errs).To(HaveLen(1))
+			Expect(errs[0].Error()).To(ContainSubstring("unknown key 'secret_environment'"))
+		})
+	})
+
+	Context("Loading includes", func() {
+		It("Merges the stages of the included configs first", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/deploy/main.yaml": `
+name: main
+include:
+- common/base.yaml
+- /shared/net.yaml
+stages:
+  boot:
+  - name: main
+`,
+				"/deploy/common/base.yaml": `
+include:
+- ../../shared/net.yaml
+stages:
+  boot:
+  - name: base
+  initramfs:
+  - name: base
+`,
+				"/shared/net.yaml": `
+stages:
+  boot:
+  - name: net
+`,
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			bhojpurConfig, err := Load("/deploy/main.yaml", fs, FromFile, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bhojpurConfig.Name).To(Equal("main"))
+			var names []string
+			for _, s := range bhojpurConfig.Stages["boot"] {
+				names = append(names, s.Name)
+			}
+			Expect(names).To(Equal([]string{"net", "base", "main"}))
+			Expect(len(bhojpurConfig.Stages["initramfs"])).To(Equal(1))
+		})
+
+		It("Scopes the variables of the included configs and merges their plugins", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/deploy/main.yaml": `
+vars:
+  role: main
+include:
+- net.yaml
+stages:
+  boot:
+  - name: main
+`,
+				"/deploy/net.yaml": `
+vars:
+  role: net
+  iface: eth0
+plugins: [commands]
+stages:
+  boot:
+  - name: net
+    vars:
+      iface: eth1
+`,
+				"/deploy/conflict.yaml": "plugins: [files]\ninclude:\n- net.yaml\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			bhojpurConfig, err := Load("/deploy/main.yaml", fs, FromFile, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bhojpurConfig.Vars).To(Equal(map[string]string{"role": "main"}))
+			Expect(bhojpurConfig.Plugins).To(Equal([]string{"commands"}))
+			Expect(bhojpurConfig.Stages["boot"][0].Vars).To(Equal(map[string]string{"role": "net", "iface": "eth1"}))
+			Expect(bhojpurConfig.Stages["boot"][1].Vars).To(BeNil())
+
+			_, err = Load("/deploy/conflict.yaml", fs, FromFile, nil)
+			Expect(err).To(MatchError(ContainSubstring("the allowed plugins commands conflict with files")))
+		})
+
+		It("Loads the configs only once across loads sharing what was loaded", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/deploy/a.yaml": "include:\n- b.yaml\nstages:\n  boot:\n  - name: a\n",
+				"/deploy/b.yaml": "stages:\n  boot:\n  - name: b\n",
+				"/deploy/c.yaml": "include:\n- a.yaml\nstages:\n  boot:\n  - name: c\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			loaded := Loaded{}
+			a, err := LoadOnce("/deploy/a.yaml", fs, FromFile, nil, loaded)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(a.Stages["boot"]).To(HaveLen(2))
+			b, err := LoadOnce("/deploy/b.yaml", fs, FromFile, nil, loaded)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(b).To(BeNil())
+			c, err := LoadOnce("/deploy/c.yaml", fs, FromFile, nil, loaded)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(c.Stages["boot"]).To(HaveLen(1))
+		})
+
+		It("Detects include cycles", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/deploy/a.yaml": "include:\n- b.yaml\n",
+				"/deploy/b.yaml": "include:\n- c.yaml\n",
+				"/deploy/c.yaml": "include:\n- /deploy/a.yaml\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			_, err = Load("/deploy/a.yaml", fs, FromFile, nil)
+			Expect(err).To(MatchError(ContainSubstring("include cycle: /deploy/a.yaml -> /deploy/b.yaml -> /deploy/c.yaml -> /deploy/a.yaml")))
+			Expect(err).To(MatchError(ContainSubstring("while including /deploy/b.yaml from /deploy/a.yaml")))
+		})
+
+		It("Detects include cycles through paths written in other forms", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/deploy/a.yaml": "include:\n- b.yaml\n",
+				"/deploy/b.yaml": "include:\n- /deploy/a.yaml\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			_, err = Load("/deploy/./a.yaml", fs, FromFile, nil)
+			Expect(err).To(MatchError(ContainSubstring("include cycle: /deploy/./a.yaml -> /deploy/b.yaml -> /deploy/a.yaml")))
+		})
+
+		It("Reports the include chain of missing configs", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/deploy/a.yaml": "include:\n- b.yaml\n",
+				"/deploy/b.yaml": "include:\n- missing.yaml\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			_, err = Load("/deploy/a.yaml", fs, FromFile, nil)
+			Expect(err).To(MatchError(ContainSubstring(
+				"while including /deploy/b.yaml from /deploy/a.yaml: while including /deploy/missing.yaml from /deploy/b.yaml",
+			)))
+		})
+
+		It("Resolves includes relative to urls", func() {
+			mux := http.NewServeMux()
+			mux.HandleFunc("/configs/main.yaml", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("include:\n- extra/net.yaml\nstages:\n  boot:\n  - name: main\n"))
+			})
+			mux.HandleFunc("/configs/extra/net.yaml", func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("stages:\n  boot:\n  - name: net\n"))
+			})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			bhojpurConfig, err := Load(srv.URL+"/configs/main.yaml", nil, FromUrl, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(len(bhojpurConfig.Stages["boot"])).To(Equal(2))
+			Expect(bhojpurConfig.Stages["boot"][0].Name).To(Equal("net"))
+		})
+	})
+
+	Context("Loading from the kernel command line", func() {
+		It("Reads the prefixed parameters in dot notation, including config urls", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/proc/cmdline": `BOOT_IMAGE=/vmlinuz quiet deploy.name=appliance deploy.stages.boot[0].commands[0]="echo hello world" ` +
+					`other.stages.boot[0].name=other deploy.config_url=/oem/net.yaml deploy.config_url=extra.yaml` + "\n",
+				"/oem/net.yaml": "stages:\n  boot:\n  - name: net\n",
+				"/extra.yaml":   "stages:\n  boot:\n  - name: extra\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			bhojpurConfig, err := Load(CmdlineScheme, fs, FromCmdline, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bhojpurConfig.Name).To(Equal("appliance"))
+			var names []string
+			for _, s := range bhojpurConfig.Stages["boot"] {
+				names = append(names, s.Name)
+			}
+			Expect(names).To(Equal([]string{"net", "extra", ""}))
+			Expect(bhojpurConfig.Stages["boot"][2].Commands).To(Equal([]string{"echo hello world"}))
+
+			bhojpurConfig, err = Load(CmdlineScheme+"other.", fs, FromCmdline, nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bhojpurConfig.Stages["boot"][0].Name).To(Equal("other"))
+		})
+	})
+
 	Context("Loading CloudConfig", func() {
 		It("Reads cloudconfig to boot stage", func() {
 			bhojpurConfig := loadstdBhojpur(`#cloud-config
@@ -0,0 +1,193 @@
+package schema
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"reflect"
+	"sort"
+
+	"github.com/bhojpur/deploy/pkg/utils"
+)
+
+// EnvVars are environment variables. Each of them can be given as a plain value, or as a
+// mapping with the value and whether it is secret, e.g. {value: s3cr3t, secret: true}.
+type EnvVars map[string]string
+
+// envVar is an environment variable, as given in the mapping form
+type envVar struct {
+	Value  string `yaml:"value"`
+	Secret bool   `yaml:"secret"`
+}
+
+func (v *envVar) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err == nil {
+		v.Value = value
+		return nil
+	}
+	type plain envVar
+	return unmarshal((*plain)(v))
+}
+
+func (e *EnvVars) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var vars map[string]envVar
+	if err := unmarshal(&vars); err != nil {
+		return err
+	}
+	*e = EnvVars{}
+	for k, v := range vars {
+		(*e)[k] = v.Value
+	}
+	return nil
+}
+
+// UnmarshalYAML decodes the stage, adding the environment variables given as secret
+// to the SecretEnvironment
+func (s *Stage) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Stage
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+
+	var env struct {
+		Environment map[string]envVar `yaml:"environment"`
+	}
+	if err := unmarshal(&env); err != nil {
+		return err
+	}
+	for k, v := range env.Environment {
+		if v.Secret {
+			s.SecretEnvironment = append(s.SecretEnvironment, k)
+		}
+	}
+	sort.Strings(s.SecretEnvironment)
+	return nil
+}
+
+// Secrets returns the sensitive values of the step: the fields tagged as secret, the
+// content of the files marked as secret and the secret environment variables.
+func (s Stage) Secrets() []string {
+	var secrets []string
+	walkSecrets(reflect.ValueOf(s), func(v reflect.Value) {
+		secrets = append(secrets, v.String())
+	})
+	for _, f := range s.Files {
+		if f.Secret {
+			secrets = append(secrets, f.Content)
+		}
+	}
+	for _, k := range s.SecretEnvironment {
+		secrets = append(secrets, s.Environment[k])
+	}
+	return secrets
+}
+
+// Redacted returns a copy of the step with its secrets masked
+func (s Stage) Redacted() Stage {
+	copied, err := utils.RenderStrings(s, func(s string) (string, error) { return s, nil })
+	if err != nil {
+		return Stage{}
+	}
+	r := copied.(Stage)
+
+	maskSecrets(reflect.ValueOf(&r).Elem())
+	for i := range r.Files {
+		if r.Files[i].Secret {
+			r.Files[i].Content = utils.Mask
+		}
+	}
+	for _, k := range r.SecretEnvironment {
+		if _, ok := r.Environment[k]; ok {
+			r.Environment[k] = utils.Mask
+		}
+	}
+	return r
+}
+
+// maskSecrets masks the strings tagged as secret in v, which must be settable
+func maskSecrets(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			maskSecrets(v.Elem())
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if field.Tag.Get("secret") == "true" && v.Field(i).Kind() == reflect.String {
+				if v.Field(i).String() != "" {
+					v.Field(i).SetString(utils.Mask)
+				}
+				continue
+			}
+			maskSecrets(v.Field(i))
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			maskSecrets(v.Index(i))
+		}
+	case reflect.Map:
+		// Map values can't be set in place, so they are masked in a copy
+		iter := v.MapRange()
+		for iter.Next() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			value.Set(iter.Value())
+			maskSecrets(value)
+			v.SetMapIndex(iter.Key(), value)
+		}
+	}
+}
+
+// walkSecrets calls f with the non empty strings tagged as secret in v
+func walkSecrets(v reflect.Value, f func(reflect.Value)) {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if !v.IsNil() {
+			walkSecrets(v.Elem(), f)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			if field.Tag.Get("secret") == "true" && v.Field(i).Kind() == reflect.String {
+				if v.Field(i).String() != "" {
+					f(v.Field(i))
+				}
+				continue
+			}
+			walkSecrets(v.Field(i), f)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkSecrets(v.Index(i), f)
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			walkSecrets(iter.Value(), f)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package schema
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/rancher-sandbox/cloud-init/config"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// ErrCloudConfig is returned by Validate for the cloud-config files, which are converted
+// to configs when loaded instead of following the schema of the configs
+var ErrCloudConfig = errors.New("cloud-config files are not checked against the schema")
+
+// ValidationError is a part of a config which doesn't match the JSON Schema of the configs
+type ValidationError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// Validate checks the YAML config b against the JSON Schema of the configs, and returns
+// the unknown keys, the values of the wrong type and the values out of their enum, in
+// the order they appear in. The error is only set if b is not valid YAML, or is ErrCloudConfig.
+func Validate(b []byte) ([]ValidationError, error) {
+	if config.IsCloudConfig(string(b)) {
+		return nil, ErrCloudConfig
+	}
+
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	v := &validator{}
+	v.validate(doc.Content[0], GenerateJSONSchema(), "")
+	sort.SliceStable(v.errs, func(i, j int) bool {
+		if v.errs[i].Line != v.errs[j].Line {
+			return v.errs[i].Line < v.errs[j].Line
+		}
+		return v.errs[i].Column < v.errs[j].Column
+	})
+	return v.errs, nil
+}
+
+type validator struct {
+	errs []ValidationError
+}
+
+func (v *validator) fail(n *yamlv3.Node, path, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{Line: n.Line, Column: n.Column, Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// validate checks the node at path against s
+func (v *validator) validate(n *yamlv3.Node, s *JSONSchema, path string) {
+	if n.Kind == yamlv3.AliasNode {
+		n = n.Alias
+	}
+	// Empty values decode to the zero value of the field
+	if n.Kind == yamlv3.ScalarNode && n.Tag == "!!null" {
+		return
+	}
+
+	if len(s.OneOf) > 0 {
+		for _, alt := range s.OneOf {
+			try := &validator{}
+			try.validate(n, alt, path)
+			if len(try.errs) == 0 {
+				return
+			}
+		}
+		v.fail(n, path, "%s: expected %s, got %s", name(path), describe(s.OneOf...), kind(n))
+		return
+	}
+
+	if !matches(n, s) {
+		v.fail(n, path, "%s: expected %s, got %s", name(path), describe(s), kind(n))
+		return
+	}
+
+	switch n.Kind {
+	case yamlv3.ScalarNode:
+		if len(s.Enum) > 0 && !contains(s.Enum, n.Value) {
+			v.fail(n, path, "%s: invalid value '%s', must be one of: %s", name(path), n.Value, strings.Join(s.Enum, ", "))
+		}
+		// Only numbers are checked, durations can also be strings
+		if f, err := strconv.ParseFloat(n.Value, 64); err == nil && s.Minimum != nil && f < *s.Minimum {
+			v.fail(n, path, "%s: invalid value '%s', must be at least %v", name(path), n.Value, *s.Minimum)
+		}
+	case yamlv3.SequenceNode:
+		for i, item := range n.Content {
+			v.validate(item, s.Items, fmt.Sprintf("%s[%d]", path, i))
+		}
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			if key.Value == "<<" {
+				// Merge keys take the keys of the mappings they refer to
+				merged := []*yamlv3.Node{value}
+				if value.Kind == yamlv3.SequenceNode {
+					merged = value.Content
+				}
+				for _, m := range merged {
+					v.validate(m, s, path)
+				}
+				continue
+			}
+			keyPath := key.Value
+			if path != "" {
+				keyPath = path + "." + key.Value
+			}
+			if prop, ok := s.Properties[key.Value]; ok {
+				v.validate(value, prop, keyPath)
+				continue
+			}
+			if s.AdditionalProperties != nil {
+				v.validate(value, s.AdditionalProperties, keyPath)
+				continue
+			}
+			if suggestion := closest(key.Value, s.Properties); suggestion != "" {
+				v.fail(key, keyPath, "%s: unknown key '%s', did you mean '%s'?", name(path), key.Value, suggestion)
+			} else {
+				v.fail(key, keyPath, "%s: unknown key '%s'", name(path), key.Value)
+			}
+		}
+	}
+}
+
+// matches tells if the kind of the node is among the types of s. Scalars match
+// strings, as yaml.v2 decodes them into strings.
+func matches(n *yamlv3.Node, s *JSONSchema) bool {
+	if len(s.Type) == 0 {
+		return true
+	}
+	for _, t := range s.Type {
+		switch t {
+		case "object":
+			if n.Kind == yamlv3.MappingNode {
+				return true
+			}
+		case "array":
+			if n.Kind == yamlv3.SequenceNode {
+				return true
+			}
+		case "string":
+			if n.Kind == yamlv3.ScalarNode {
+				return true
+			}
+		case "integer":
+			if n.Kind == yamlv3.ScalarNode && n.Tag == "!!int" {
+				return true
+			}
+		case "number":
+			if n.Kind == yamlv3.ScalarNode && (n.Tag == "!!int" || n.Tag == "!!float") {
+				return true
+			}
+		case "boolean":
+			if n.Kind == yamlv3.ScalarNode && (n.Tag == "!!bool" || isYAML11Bool(n)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isYAML11Bool tells if the node is one of the booleans of YAML 1.1, which yaml.v2 decodes
+// as booleans but yaml.v3 takes for strings, e.g. yes or off
+func isYAML11Bool(n *yamlv3.Node) bool {
+	if n.Tag != "!!str" || n.Style != 0 {
+		return false
+	}
+	switch strings.ToLower(n.Value) {
+	case "y", "yes", "n", "no", "on", "off":
+		return true
+	}
+	return false
+}
+
+// kind describes the kind of value of a node
+func kind(n *yamlv3.Node) string {
+	switch n.Kind {
+	case yamlv3.MappingNode:
+		return "a mapping"
+	case yamlv3.SequenceNode:
+		return "a list"
+	}
+	switch n.Tag {
+	case "!!int":
+		return "an integer"
+	case "!!float":
+		return "a number"
+	case "!!bool":
+		return "a boolean"
+	}
+	return "a string"
+}
+
+// describe describes the values the schemas accept
+func describe(schemas ...*JSONSchema) string {
+	var kinds []string
+	for _, s := range schemas {
+		for _, t := range s.Type {
+			switch t {
+			case "object":
+				kinds = append(kinds, "a mapping")
+			case "array":
+				kinds = append(kinds, "a list")
+			case "integer":
+				kinds = append(kinds, "an integer")
+			default:
+				kinds = append(kinds, "a "+t)
+			}
+		}
+	}
+	return strings.Join(kinds, " or ")
+}
+
+// name returns the name of the value at path, for the messages
+func name(path string) string {
+	if path == "" {
+		return "config"
+	}
+	return path
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// closest returns the property the unknown key is most likely a typo of, if any
+func closest(key string, properties map[string]*JSONSchema) string {
+	best, bestDistance := "", 3
+	for p := range properties {
+		if d := distance(key, p); d < bestDistance || d == bestDistance && p < best {
+			best, bestDistance = p, d
+		}
+	}
+	if bestDistance > 2 {
+		return ""
+	}
+	return best
+}
+
+// distance returns the Levenshtein distance between a and b
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,127 @@
+package schema_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+
+	. "github.com/bhojpur/deploy/pkg/schema"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validate", func() {
+	It("accepts valid configs", func() {
+		errs, err := Validate([]byte(`
+name: "test"
+stages:
+  boot:
+  - name: "files"
+    timeout: 30s
+    environment:
+      FOO: bar
+    files:
+    - path: /tmp/foo
+      encoding: b64
+      permissions: 0644
+    layout:
+      add_partitions:
+      - fsLabel: data
+        filesystem: ext4
+`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(errs).To(BeEmpty())
+	})
+
+	It("reports unknown keys with their position and a suggestion", func() {
+		errs, err := Validate([]byte(`stages:
+  boot:
+  - name: "sysctl"
+    systctl:
+      foo: bar
+`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Line).To(Equal(4))
+		Expect(errs[0].Column).To(Equal(5))
+		Expect(errs[0].Path).To(Equal("stages.boot[0].systctl"))
+		Expect(errs[0].Error()).To(Equal("4:5: stages.boot[0]: unknown key 'systctl', did you mean 'sysctl'?"))
+	})
+
+	It("reports values of the wrong type", func() {
+		errs, err := Validate([]byte(`stages:
+  boot:
+  - commands: "echo foo"
+`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Line).To(Equal(3))
+		Expect(errs[0].Message).To(ContainSubstring("stages.boot[0].commands: expected a list"))
+	})
+
+	It("reports values not among the allowed ones", func() {
+		errs, err := Validate([]byte(`stages:
+  boot:
+  - files:
+    - path: /tmp/foo
+      encoding: base46
+`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Line).To(Equal(5))
+		Expect(errs[0].Message).To(ContainSubstring("invalid value 'base46'"))
+	})
+
+	It("reports values below their minimum", func() {
+		errs, err := Validate([]byte(`stages:
+  boot:
+  - retries: -1
+    retry_delay: 5s
+`))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Line).To(Equal(3))
+		Expect(errs[0].Message).To(ContainSubstring("invalid value '-1', must be at least 0"))
+	})
+
+	It("skips cloud-config files", func() {
+		_, err := Validate([]byte("#cloud-config\nhostname: foo\n"))
+		Expect(err).To(Equal(ErrCloudConfig))
+	})
+
+	It("fails on invalid YAML", func() {
+		_, err := Validate([]byte("stages: [\n"))
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+var _ = Describe("GenerateJSONSchema", func() {
+	It("closes the objects of the configs", func() {
+		b, err := json.Marshal(GenerateJSONSchema())
+		Expect(err).ToNot(HaveOccurred())
+
+		var s map[string]interface{}
+		Expect(json.Unmarshal(b, &s)).To(Succeed())
+		Expect(s["$schema"]).To(Equal(JSONSchemaDraft))
+		Expect(s["additionalProperties"]).To(Equal(false))
+		Expect(s["properties"]).To(HaveKey("stages"))
+	})
+})
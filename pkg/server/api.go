@@ -0,0 +1,163 @@
+package server
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bhojpur/deploy/pkg/schema"
+)
+
+// maxConfigSize is the maximum size of the configs submitted
+const maxConfigSize = 4 << 20
+
+// runRequest is the body of the requests submitting a run
+type runRequest struct {
+	Stages []string `json:"stages"`
+}
+
+// Handler returns the handler of the API:
+//
+//	GET    /configs             names of the submitted configs
+//	PUT    /configs/<name>      submit a config, as YAML, applied by the next runs
+//	DELETE /configs/<name>      remove a submitted config
+//	GET    /runs                the runs, the latest last
+//	POST   /runs                queue a run, with a body like {"stages": ["boot"]}
+//	GET    /runs/<id>           status of a run
+//	GET    /runs/<id>/report    report of a run, once started
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/configs", s.handleConfigs)
+	mux.HandleFunc("/configs/", s.handleConfig)
+	mux.HandleFunc("/runs", s.handleRuns)
+	mux.HandleFunc("/runs/", s.handleRun)
+	return mux
+}
+
+func (s *Server) handleConfigs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.Configs())
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/configs/")
+	if name == "" || strings.Contains(name, "/") {
+		writeError(w, http.StatusNotFound, fmt.Errorf("invalid config name '%s'", name))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigSize))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		// The configs it includes are only loaded by the runs, as the sources are
+		if _, err := schema.Parse(string(data)); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid config: %w", err))
+			return
+		}
+		s.SetConfig(name, string(data))
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if !s.DeleteConfig(name) {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no config named '%s'", name))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (s *Server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Runs())
+	case http.MethodPost:
+		var req runRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid run request: %w", err))
+			return
+		}
+		if len(req.Stages) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("no stages given"))
+			return
+		}
+		run, err := s.Submit(req.Stages)
+		if err != nil {
+			writeError(w, http.StatusServiceUnavailable, err)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/runs/%d", run.ID))
+		writeJSON(w, http.StatusAccepted, run)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+func (s *Server) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/runs/"), "/")
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) > 2 || (len(parts) == 2 && parts[1] != "report") {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such endpoint %s", r.URL.Path))
+		return
+	}
+	run, report, ok := s.Get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no run %d", id))
+		return
+	}
+
+	if len(parts) == 1 {
+		writeJSON(w, http.StatusOK, run)
+		return
+	}
+	if report == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("run %d has not started", id))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	report.WriteJSON(w)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
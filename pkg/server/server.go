@@ -0,0 +1,313 @@
+package server
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bhojpur/deploy/pkg/console"
+	"github.com/bhojpur/deploy/pkg/executor"
+	"github.com/bhojpur/deploy/pkg/logger"
+	"github.com/bhojpur/deploy/pkg/plugins"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs"
+)
+
+// Statuses of a Run
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusSuccess = executor.StatusSuccess
+	StatusFailure = executor.StatusFailure
+)
+
+// ErrQueueFull is returned when submitting a run while the queue is full
+var ErrQueueFull = errors.New("the run queue is full")
+
+// Run is a run of stages requested to the server
+type Run struct {
+	ID       int        `json:"id"`
+	Stages   []string   `json:"stages"`
+	Status   string     `json:"status"`
+	Error    string     `json:"error,omitempty"`
+	Queued   time.Time  `json:"queued"`
+	Started  *time.Time `json:"started,omitempty"`
+	Finished *time.Time `json:"finished,omitempty"`
+
+	report *executor.RunReport
+}
+
+// Server runs the stages requested through its API one at a time, with a new executor
+// for each run. The runs apply the sources of the server along with the configs
+// submitted to it, sorted by name.
+type Server struct {
+	newExecutor func() executor.Executor
+	fs          vfs.FS
+	console     plugins.Console
+	logger      logger.Interface
+	sources     []string
+	timeout     time.Duration
+	history     int
+	redact      func(string) string
+
+	mu      sync.Mutex
+	configs map[string]string
+	runs    []*Run
+	nextID  int
+	queue   chan *Run
+}
+
+type Options func(s *Server) error
+
+// WithLogger sets the logger of the server
+func WithLogger(l logger.Interface) Options {
+	return func(s *Server) error {
+		s.logger = l
+		return nil
+	}
+}
+
+// WithSources sets the paths and urls the runs load configs from
+func WithSources(sources ...string) Options {
+	return func(s *Server) error {
+		s.sources = sources
+		return nil
+	}
+}
+
+// WithFS sets the filesystem the runs apply the configs to
+func WithFS(fs vfs.FS) Options {
+	return func(s *Server) error {
+		s.fs = fs
+		return nil
+	}
+}
+
+// WithConsole sets the console the runs run the commands with
+func WithConsole(c plugins.Console) Options {
+	return func(s *Server) error {
+		s.console = c
+		return nil
+	}
+}
+
+// WithQueueSize sets how many runs can wait for the current one to finish
+func WithQueueSize(n int) Options {
+	return func(s *Server) error {
+		if n < 1 {
+			return errors.New("the queue size must be at least 1")
+		}
+		s.queue = make(chan *Run, n)
+		return nil
+	}
+}
+
+// WithRunTimeout sets the maximum time a run can take, 0 for no limit
+func WithRunTimeout(d time.Duration) Options {
+	return func(s *Server) error {
+		s.timeout = d
+		return nil
+	}
+}
+
+// WithRedaction makes the server pass the errors of the runs through redact, e.g. to
+// mask the secrets of the steps
+func WithRedaction(redact func(string) string) Options {
+	return func(s *Server) error {
+		s.redact = redact
+		return nil
+	}
+}
+
+// New returns a server creating the executor of each run with newExecutor
+func New(newExecutor func() executor.Executor, opts ...Options) *Server {
+	s := &Server{
+		newExecutor: newExecutor,
+		fs:          vfs.OSFS,
+		console:     console.NewStandardConsole(),
+		logger:      logrus.New(),
+		history:     100,
+		redact:      func(s string) string { return s },
+		configs:     map[string]string{},
+		queue:       make(chan *Run, 16),
+		nextID:      1,
+	}
+	for _, o := range opts {
+		if err := o(s); err != nil {
+			s.logger.Warnf("Invalid server option: %s", err.Error())
+		}
+	}
+	return s
+}
+
+// Serve serves the API on l and runs the submitted runs, until ctx is done. The run
+// in progress is then interrupted, and the queued ones are dropped.
+func (s *Server) Serve(ctx context.Context, l net.Listener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.work(ctx)
+	}()
+
+	srv := &http.Server{Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	err := srv.Serve(l)
+	cancel()
+	<-done
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// Submit queues a run of the stages
+func (s *Server) Submit(stages []string) (Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := &Run{ID: s.nextID, Stages: stages, Status: StatusQueued, Queued: time.Now()}
+	select {
+	case s.queue <- r:
+	default:
+		return Run{}, ErrQueueFull
+	}
+	s.nextID++
+	s.runs = append(s.runs, r)
+	// Finished runs are forgotten past the history size
+	for len(s.runs) > s.history && s.runs[0].Finished != nil {
+		s.runs = s.runs[1:]
+	}
+	return *r, nil
+}
+
+// Runs returns the runs known to the server, the latest last
+func (s *Server) Runs() []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	runs := make([]Run, len(s.runs))
+	for i, r := range s.runs {
+		runs[i] = *r
+	}
+	return runs
+}
+
+// Get returns the run with the given id, and its report once started
+func (s *Server) Get(id int) (Run, *executor.RunReport, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.runs {
+		if r.ID == id {
+			return *r, r.report, true
+		}
+	}
+	return Run{}, nil, false
+}
+
+// SetConfig adds or replaces the submitted config with the given name
+func (s *Server) SetConfig(name, config string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.configs[name] = config
+}
+
+// DeleteConfig removes the submitted config with the given name, and tells if it existed
+func (s *Server) DeleteConfig(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.configs[name]
+	delete(s.configs, name)
+	return ok
+}
+
+// Configs returns the names of the submitted configs, sorted
+func (s *Server) Configs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return sortedKeys(s.configs)
+}
+
+// work runs the queued runs one at a time, until ctx is done
+func (s *Server) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-s.queue:
+			s.run(ctx, r)
+		}
+	}
+}
+
+func (s *Server) run(ctx context.Context, r *Run) {
+	e := s.newExecutor()
+
+	s.mu.Lock()
+	started := time.Now()
+	r.Status, r.Started, r.report = StatusRunning, &started, e.Report()
+	// The submitted configs are given inline, after the sources
+	sources := append([]string{}, s.sources...)
+	for _, name := range sortedKeys(s.configs) {
+		sources = append(sources, s.configs[name])
+	}
+	s.mu.Unlock()
+
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	s.logger.Infof("Starting run %d of %v", r.ID, r.Stages)
+	err := e.RunStages(ctx, r.Stages, s.fs, s.console, sources...)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	finished := time.Now()
+	r.Finished = &finished
+	r.Status = StatusSuccess
+	if err != nil {
+		r.Status, r.Error = StatusFailure, s.redact(err.Error())
+	}
+	s.logger.Infof("Run %d finished: %s", r.ID, r.Status)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,159 @@
+package server_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/bhojpur/deploy/pkg/executor"
+	"github.com/bhojpur/deploy/pkg/plugins"
+	. "github.com/bhojpur/deploy/pkg/server"
+	consoletests "github.com/bhojpur/deploy/tests/console"
+	"github.com/sirupsen/logrus"
+	"github.com/twpayne/go-vfs/vfst"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	Context("serving the API", func() {
+		It("runs the stages with the sources and the submitted configs", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/oem/10_base.yaml": "stages:\n  net:\n  - commands: [echo base]\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			srv := New(
+				func() executor.Executor {
+					return executor.NewExecutor(executor.WithLogger(logrus.New()), executor.WithPlugins(plugins.Commands))
+				},
+				WithLogger(logrus.New()),
+				WithFS(fs),
+				WithConsole(consoletests.TestConsole{}),
+				WithSources("/oem"),
+			)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go srv.Serve(ctx, l)
+			url := "http://" + l.Addr().String()
+
+			req, _ := http.NewRequest(http.MethodPut, url+"/configs/extra", strings.NewReader("stages:\n  net:\n  - commands: [echo extra]\n"))
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+
+			req, _ = http.NewRequest(http.MethodPut, url+"/configs/broken", strings.NewReader("stages: ["))
+			resp, err = http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+
+			consoletests.Reset()
+			resp, err = http.Post(url+"/runs", "application/json", strings.NewReader(`{"stages": ["net"]}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+			Expect(resp.Header.Get("Location")).To(Equal("/runs/1"))
+
+			status := func() string {
+				resp, err := http.Get(url + "/runs/1")
+				Expect(err).ToNot(HaveOccurred())
+				defer resp.Body.Close()
+				var run Run
+				Expect(json.NewDecoder(resp.Body).Decode(&run)).To(Succeed())
+				return run.Status
+			}
+			Eventually(status).Should(Equal(StatusSuccess))
+			Expect(consoletests.Commands).To(Equal([]string{"echo base", "echo extra"}))
+
+			resp, err = http.Get(url + "/runs/1/report")
+			Expect(err).ToNot(HaveOccurred())
+			var report executor.RunReport
+			Expect(json.NewDecoder(resp.Body).Decode(&report)).To(Succeed())
+			Expect(report.Stages[0].Name).To(Equal("net"))
+			Expect(report.Stages[0].Status).To(Equal(executor.StatusSuccess))
+
+			resp, err = http.Get(url + "/runs/2")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+
+		It("loads the configs the submitted ones include with the runs", func() {
+			fs, cleanup, err := vfst.NewTestFS(map[string]interface{}{
+				"/inc/extra.yaml": "stages:\n  net:\n  - commands: [echo included]\n",
+			})
+			Expect(err).Should(BeNil())
+			defer cleanup()
+
+			srv := New(
+				func() executor.Executor {
+					return executor.NewExecutor(executor.WithLogger(logrus.New()), executor.WithPlugins(plugins.Commands))
+				},
+				WithLogger(logrus.New()),
+				WithFS(fs),
+				WithConsole(consoletests.TestConsole{}),
+			)
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).ToNot(HaveOccurred())
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go srv.Serve(ctx, l)
+			url := "http://" + l.Addr().String()
+
+			req, _ := http.NewRequest(http.MethodPut, url+"/configs/extra", strings.NewReader("include: [/inc/extra.yaml]\n"))
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+
+			consoletests.Reset()
+			resp, err = http.Post(url+"/runs", "application/json", strings.NewReader(`{"stages": ["net"]}`))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+			status := func() string {
+				resp, err := http.Get(url + "/runs/1")
+				Expect(err).ToNot(HaveOccurred())
+				defer resp.Body.Close()
+				var run Run
+				Expect(json.NewDecoder(resp.Body).Decode(&run)).To(Succeed())
+				return run.Status
+			}
+			Eventually(status).Should(Equal(StatusSuccess))
+			Expect(consoletests.Commands).To(Equal([]string{"echo included"}))
+		})
+
+		It("refuses runs once the queue is full", func() {
+			// Without serving, nothing takes the runs from the queue
+			srv := New(func() executor.Executor { return executor.NewExecutor() }, WithQueueSize(1))
+			_, err := srv.Submit([]string{"boot"})
+			Expect(err).ToNot(HaveOccurred())
+			_, err = srv.Submit([]string{"boot"})
+			Expect(err).To(Equal(ErrQueueFull))
+			Expect(srv.Runs()).To(HaveLen(1))
+			Expect(srv.Runs()[0].Status).To(Equal(StatusQueued))
+		})
+	})
+})
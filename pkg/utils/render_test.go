@@ -0,0 +1,58 @@
+package utils_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"errors"
+	"strings"
+
+	. "github.com/bhojpur/deploy/pkg/utils"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Utils", func() {
+	Context("rendering", func() {
+		It("renders the strings of a value into a copy of it", func() {
+			type item struct {
+				Name string `yaml:"name"`
+			}
+			type value struct {
+				Items  []item            `yaml:"items"`
+				Labels map[string]string `yaml:"labels"`
+				Ptr    *item
+				Count  int
+			}
+			upper := func(s string) (string, error) { return strings.ToUpper(s), nil }
+
+			v := value{Items: []item{{Name: "a"}}, Labels: map[string]string{"k": "b"}, Ptr: &item{Name: "c"}, Count: 1}
+			rendered, err := RenderStrings(v, upper)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(rendered).To(Equal(value{Items: []item{{Name: "A"}}, Labels: map[string]string{"k": "B"}, Ptr: &item{Name: "C"}, Count: 1}))
+			Expect(v.Items[0].Name).To(Equal("a"))
+			Expect(v.Ptr.Name).To(Equal("c"))
+
+			_, err = RenderStrings(v, func(s string) (string, error) { return "", errors.New("broken") })
+			Expect(err).To(MatchError("items[0].name: broken"))
+		})
+	})
+})
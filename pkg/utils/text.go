@@ -24,6 +24,7 @@ import (
 	"bytes"
 	"math/rand"
 	"text/template"
+	"text/template/parse"
 
 	"github.com/Masterminds/sprig/v3"
 )
@@ -43,6 +44,79 @@ func TemplatedString(t string, i interface{}) (string, error) {
 	return b.String(), err
 }
 
+// StrictTemplatedString is TemplatedString, failing if the template refers to missing map keys
+func StrictTemplatedString(t string, i interface{}) (string, error) {
+	b := bytes.NewBuffer([]byte{})
+	tmpl, err := template.New("template").Funcs(sprig.TxtFuncMap()).Option("missingkey=error").Parse(t)
+	if err != nil {
+		return "", err
+	}
+
+	err = tmpl.Execute(b, i)
+
+	return b.String(), err
+}
+
+// TemplateFields returns the names of the fields of root the template t refers to, e.g.
+// foo for {{.Vars.foo}} with the root Vars. Fields given by index are not returned.
+func TemplateFields(t, root string) ([]string, error) {
+	tmpl, err := template.New("template").Funcs(sprig.TxtFuncMap()).Parse(t)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []string
+	var walk func(n parse.Node)
+	walk = func(n parse.Node) {
+		switch n := n.(type) {
+		case *parse.ListNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Nodes {
+				walk(c)
+			}
+		case *parse.ActionNode:
+			walk(n.Pipe)
+		case *parse.TemplateNode:
+			walk(n.Pipe)
+		case *parse.IfNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.RangeNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.WithNode:
+			walk(n.Pipe)
+			walk(n.List)
+			walk(n.ElseList)
+		case *parse.PipeNode:
+			if n == nil {
+				return
+			}
+			for _, c := range n.Cmds {
+				walk(c)
+			}
+		case *parse.CommandNode:
+			for _, a := range n.Args {
+				walk(a)
+			}
+		case *parse.ChainNode:
+			walk(n.Node)
+		case *parse.FieldNode:
+			if len(n.Ident) > 1 && n.Ident[0] == root {
+				fields = append(fields, n.Ident[1])
+			}
+		}
+	}
+	if tmpl.Tree != nil {
+		walk(tmpl.Tree.Root)
+	}
+	return fields, nil
+}
+
 var letters = []rune("1234567890abcdefghijklmnopqrstuvwxyz")
 
 func RandomString(n int) string {
@@ -40,6 +40,20 @@ var _ = Describe("Utils", func() {
 			Expect(str).Should(ContainSubstring("foo-"))
 			Expect(len(str)).To(Equal(4))
 		})
+
+		It("fails on missing keys when strict", func() {
+			_, err := StrictTemplatedString("{{.foo}}", map[string]string{})
+			Expect(err).Should(HaveOccurred())
+		})
+
+		It("lists the fields of a root the template refers to", func() {
+			fields, err := TemplateFields(`{{.Vars.a}} {{if .Vars.b}}{{upper .Vars.c}}{{end}} {{.Values.d}} {{.Names}}`, "Vars")
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(fields).To(Equal([]string{"a", "b", "c"}))
+
+			_, err = TemplateFields("{{.Vars.a", "Vars")
+			Expect(err).Should(HaveOccurred())
+		})
 	})
 	Context("random", func() {
 		It("Generates strings of the correct length", func() {
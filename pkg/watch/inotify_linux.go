@@ -0,0 +1,135 @@
+package watch
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB
+
+// watcher watches directories and their sub directories with inotify
+type watcher struct {
+	f    *os.File
+	fd   int
+	mu   sync.Mutex
+	dirs map[int]string
+	buf  []byte
+}
+
+func newWatcher(dirs []string) (*watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// The file is non blocking, so closing it interrupts the reads
+	w := &watcher{
+		f:    os.NewFile(uintptr(fd), "inotify"),
+		fd:   fd,
+		dirs: map[int]string{},
+		buf:  make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1)),
+	}
+	for _, dir := range dirs {
+		if _, err := w.addTree(filepath.Clean(dir)); err != nil {
+			w.close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// addTree watches dir and the directories under it, and returns the files under them
+func (w *watcher) addTree(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			return &os.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+		}
+		w.mu.Lock()
+		w.dirs[wd] = path
+		w.mu.Unlock()
+		return nil
+	})
+	return files, err
+}
+
+// read waits for changes, and returns the paths changed
+func (w *watcher) read() ([]string, error) {
+	n, err := w.f.Read(w.buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+		ev := (*syscall.InotifyEvent)(unsafe.Pointer(&w.buf[offset]))
+		name := w.buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(ev.Len)]
+		offset += syscall.SizeofInotifyEvent + int(ev.Len)
+
+		w.mu.Lock()
+		dir, ok := w.dirs[int(ev.Wd)]
+		if ev.Mask&syscall.IN_IGNORED != 0 {
+			delete(w.dirs, int(ev.Wd))
+		}
+		w.mu.Unlock()
+		if !ok || ev.Len == 0 {
+			continue
+		}
+
+		path := filepath.Join(dir, string(trimNull(name)))
+		paths = append(paths, path)
+		// New directories are watched too, along with what was created in them meanwhile
+		if ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+			files, err := w.addTree(path)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			paths = append(paths, files...)
+		}
+	}
+	return paths, nil
+}
+
+func (w *watcher) close() error {
+	return w.f.Close()
+}
+
+func trimNull(b []byte) []byte {
+	for i, c := range b {
+		if c == 0 {
+			return b[:i]
+		}
+	}
+	return b
+}
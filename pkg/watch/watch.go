@@ -0,0 +1,111 @@
+package watch
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/bhojpur/deploy/pkg/logger"
+)
+
+// Watch reports the files created, written, moved or removed under the directories, and
+// the directories created under them. Changes are reported in batches, once no other
+// change happened for debounce. A batch not received yet is merged with the next one, so
+// that a slow receiver gets the changes at once. The returned channel is closed once ctx
+// is done, or if watching fails, which is logged.
+func Watch(ctx context.Context, l logger.Interface, debounce time.Duration, dirs ...string) (<-chan []string, error) {
+	w, err := newWatcher(dirs)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan string)
+	go func() {
+		defer close(events)
+		for {
+			paths, err := w.read()
+			if err != nil {
+				if ctx.Err() == nil {
+					l.Errorf("Watching %v failed: %s", dirs, err.Error())
+				}
+				return
+			}
+			for _, p := range paths {
+				select {
+				case events <- p:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	changes := make(chan []string, 1)
+	go func() {
+		defer close(changes)
+		defer w.close()
+
+		pending := map[string]bool{}
+		var timer <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case p, ok := <-events:
+				if !ok {
+					return
+				}
+				pending[p] = true
+				timer = time.After(debounce)
+			case <-timer:
+				timer = nil
+				select {
+				case changes <- sortedPaths(pending):
+				default:
+					// The previous batch wasn't received yet: merge it with the new
+					// changes. The channel is then empty, and this is its only sender.
+					select {
+					case prev := <-changes:
+						for _, p := range prev {
+							pending[p] = true
+						}
+					default:
+					}
+					changes <- sortedPaths(pending)
+				}
+				pending = map[string]bool{}
+			}
+		}
+	}()
+	return changes, nil
+}
+
+// sortedPaths returns the paths of the set, sorted
+func sortedPaths(set map[string]bool) []string {
+	paths := make([]string, 0, len(set))
+	for p := range set {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
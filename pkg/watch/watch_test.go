@@ -0,0 +1,89 @@
+package watch_test
+
+// Copyright (c) 2018 Bhojpur Consulting Private Limited, India. All rights reserved.
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/bhojpur/deploy/pkg/watch"
+	"github.com/sirupsen/logrus"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Watch", func() {
+	Context("watching directories", func() {
+		It("reports the changed files in debounced batches", func() {
+			dir, err := ioutil.TempDir("", "watch")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			Expect(ioutil.WriteFile(filepath.Join(dir, "a.yaml"), []byte("a"), 0644)).To(Succeed())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			changes, err := Watch(ctx, logrus.New(), 200*time.Millisecond, dir)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(ioutil.WriteFile(filepath.Join(dir, "a.yaml"), []byte("b"), 0644)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(dir, "a.yaml.d"), 0755)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(dir, "a.yaml.d", "b.yaml"), []byte("b"), 0644)).To(Succeed())
+
+			var batch []string
+			Eventually(changes, 5*time.Second).Should(Receive(&batch))
+			Expect(batch).To(ContainElements(filepath.Join(dir, "a.yaml"), filepath.Join(dir, "a.yaml.d", "b.yaml")))
+
+			Expect(os.Remove(filepath.Join(dir, "a.yaml"))).To(Succeed())
+			Eventually(changes, 5*time.Second).Should(Receive(Equal([]string{filepath.Join(dir, "a.yaml")})))
+
+			cancel()
+			Eventually(changes, 5*time.Second).Should(BeClosed())
+		})
+
+		It("merges the batches not received yet", func() {
+			dir, err := ioutil.TempDir("", "watch")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			changes, err := Watch(ctx, logrus.New(), 50*time.Millisecond, dir)
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(ioutil.WriteFile(filepath.Join(dir, "a.yaml"), []byte("a"), 0644)).To(Succeed())
+			time.Sleep(500 * time.Millisecond)
+			Expect(ioutil.WriteFile(filepath.Join(dir, "b.yaml"), []byte("b"), 0644)).To(Succeed())
+			time.Sleep(500 * time.Millisecond)
+
+			Expect(changes).To(Receive(Equal([]string{filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")})))
+			Consistently(changes, 200*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("fails on missing directories", func() {
+			_, err := Watch(context.Background(), logrus.New(), time.Second, "/does/not/exist")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})